@@ -0,0 +1,184 @@
+package apikeys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/getblank/uuid"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/getblank/blank-sr/bdb"
+	"github.com/getblank/blank-sr/berror"
+	"github.com/getblank/blank-sr/config"
+)
+
+// AllScopes is a scope that grants access to any scope
+const AllScopes = "*"
+
+var (
+	bucket = config.ApiKeysBucket
+	db     = bdb.DB{}
+	locker sync.Mutex
+
+	// lastUsedPrecision limits how often LastUsedAt is saved to DB
+	lastUsedPrecision = time.Minute
+
+	// ErrInvalidKey returns when key is malformed, unknown or its secret does not match
+	ErrInvalidKey = errors.New("invalid api key")
+	// ErrKeyExpired returns when key is expired
+	ErrKeyExpired = errors.New("api key expired")
+	// ErrScopeNotAllowed returns when key has no requested scope
+	ErrScopeNotAllowed = errors.New("scope not allowed")
+)
+
+// Key represents long-lived API key. Only hash of the key secret is stored.
+type Key struct {
+	ID         string      `json:"id"`
+	Name       string      `json:"name"`
+	UserID     interface{} `json:"userId"`
+	Scopes     []string    `json:"scopes"`
+	Hash       string      `json:"hash,omitempty"`
+	CreatedAt  time.Time   `json:"createdAt"`
+	ExpiresAt  *time.Time  `json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time  `json:"lastUsedAt,omitempty"`
+}
+
+// Create creates new API key and returns its plain value and description.
+// Plain value is returned only once and can't be restored later.
+// Key never expires if ttl is 0.
+func Create(name string, userID interface{}, scopes []string, ttl time.Duration) (string, *Key, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+
+	secret := hex.EncodeToString(b)
+	now := time.Now()
+	k := &Key{
+		ID:        uuid.NewV4(),
+		Name:      name,
+		UserID:    userID,
+		Scopes:    scopes,
+		Hash:      hash(secret),
+		CreatedAt: now,
+	}
+	if ttl > 0 {
+		expiresAt := now.Add(ttl)
+		k.ExpiresAt = &expiresAt
+	}
+
+	if err := db.Save(bucket, k.ID, k); err != nil {
+		return "", nil, err
+	}
+
+	log.Infof("API key %q created for user %v with scopes %v", k.ID, userID, scopes)
+
+	return k.ID + "." + secret, k.public(), nil
+}
+
+// List returns all keys of the user provided or keys of all users if userID is nil.
+func List(userID interface{}) ([]*Key, error) {
+	all, err := db.GetAll(bucket)
+	if err != nil && err != berror.DbNotFound {
+		return nil, err
+	}
+
+	result := []*Key{}
+	for _, encoded := range all {
+		var k Key
+		if err := json.Unmarshal(encoded, &k); err != nil {
+			log.Error("Can't unmarshal API key", err.Error())
+			continue
+		}
+
+		if userID == nil || k.UserID == userID {
+			result = append(result, k.public())
+		}
+	}
+
+	return result, nil
+}
+
+// Revoke deletes key by its ID
+func Revoke(id string) error {
+	locker.Lock()
+	defer locker.Unlock()
+
+	if _, err := db.Get(bucket, id); err != nil {
+		return err
+	}
+
+	log.Infof("API key %q revoked", id)
+
+	return db.Delete(bucket, id)
+}
+
+// Verify checks plain key and returns its description.
+// If scope is not empty, key must have it or AllScopes.
+func Verify(plain, scope string) (*Key, error) {
+	parts := strings.SplitN(plain, ".", 2)
+	if len(parts) != 2 {
+		return nil, ErrInvalidKey
+	}
+
+	locker.Lock()
+	defer locker.Unlock()
+
+	var k Key
+	if err := db.GetUnmarshalledIntoInterface(bucket, parts[0], &k); err != nil {
+		return nil, ErrInvalidKey
+	}
+
+	if subtle.ConstantTimeCompare([]byte(k.Hash), []byte(hash(parts[1]))) != 1 {
+		return nil, ErrInvalidKey
+	}
+
+	now := time.Now()
+	if k.ExpiresAt != nil && k.ExpiresAt.Before(now) {
+		return nil, ErrKeyExpired
+	}
+
+	if scope != "" && !k.hasScope(scope) {
+		return nil, ErrScopeNotAllowed
+	}
+
+	if k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) > lastUsedPrecision {
+		k.LastUsedAt = &now
+		if err := db.Save(bucket, k.ID, k); err != nil {
+			log.Error("Can't save API key last used time", err.Error())
+		}
+	}
+
+	return k.public(), nil
+}
+
+func (k *Key) hasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == AllScopes {
+			return true
+		}
+	}
+
+	return false
+}
+
+// public returns copy of the key without hash
+func (k *Key) public() *Key {
+	_k := *k
+	_k.Hash = ""
+
+	return &_k
+}
+
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+
+	return hex.EncodeToString(sum[:])
+}
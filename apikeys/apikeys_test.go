@@ -0,0 +1,83 @@
+package apikeys
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+)
+
+func TestAPIKeys(t *testing.T) {
+	g := Goblin(t)
+	g.Describe("API Keys", func() {
+		g.Before(func() {
+			db.DeleteBucket(bucket)
+		})
+
+		g.Describe("#Create", func() {
+			g.It("Should store only hash of the key", func() {
+				plain, k, err := Create("integration", "123", []string{"read"}, 0)
+				g.Assert(err == nil).IsTrue()
+				g.Assert(k.Hash).Equal("")
+				g.Assert(k.ExpiresAt == nil).IsTrue()
+
+				var stored Key
+				db.GetUnmarshalledIntoInterface(bucket, k.ID, &stored)
+				g.Assert(stored.Hash != "").IsTrue()
+				g.Assert(stored.Hash == plain).IsFalse()
+			})
+		})
+
+		g.Describe("#Verify", func() {
+			g.It("Should return key for valid plain value", func() {
+				plain, k, _ := Create("integration", "234", []string{"read"}, time.Hour)
+				verified, err := Verify(plain, "read")
+				g.Assert(err == nil).IsTrue()
+				g.Assert(verified.ID).Equal(k.ID)
+				g.Assert(verified.UserID).Equal("234")
+				g.Assert(verified.LastUsedAt == nil).IsFalse()
+			})
+			g.It("Should reject wrong secret", func() {
+				_, k, _ := Create("integration", "345", nil, 0)
+				_, err := Verify(k.ID+".wrong", "")
+				g.Assert(err).Equal(ErrInvalidKey)
+				_, err = Verify("malformed", "")
+				g.Assert(err).Equal(ErrInvalidKey)
+			})
+			g.It("Should reject not granted scope", func() {
+				plain, _, _ := Create("integration", "456", []string{"read"}, 0)
+				_, err := Verify(plain, "write")
+				g.Assert(err).Equal(ErrScopeNotAllowed)
+				plain, _, _ = Create("integration", "456", []string{AllScopes}, 0)
+				_, err = Verify(plain, "write")
+				g.Assert(err == nil).IsTrue()
+			})
+			g.It("Should reject expired key", func() {
+				plain, _, _ := Create("integration", "567", nil, time.Millisecond)
+				time.Sleep(time.Millisecond * 5)
+				_, err := Verify(plain, "")
+				g.Assert(err).Equal(ErrKeyExpired)
+			})
+		})
+
+		g.Describe("#List", func() {
+			g.It("Should return keys of the user", func() {
+				Create("first", "678", nil, 0)
+				Create("second", "678", nil, 0)
+				keys, err := List("678")
+				g.Assert(err == nil).IsTrue()
+				g.Assert(len(keys)).Equal(2)
+				g.Assert(keys[0].Hash).Equal("")
+			})
+		})
+
+		g.Describe("#Revoke", func() {
+			g.It("Should delete key", func() {
+				plain, k, _ := Create("integration", "789", nil, 0)
+				g.Assert(Revoke(k.ID) == nil).IsTrue()
+				_, err := Verify(plain, "")
+				g.Assert(err).Equal(ErrInvalidKey)
+			})
+		})
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -47,22 +48,23 @@ func (DB) Connected() bool {
 	return Opened()
 }
 
+// Inc adds inc to the number prop of the document in one transaction and returns updated document.
+// Not existing document is created.
 func (DB) Inc(bucket, key, propPath string, inc float64) (result M, err error) {
 	BoltDB.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(bucket))
-		if b == nil {
-			err = berror.DbNotFound
-			return err
-		}
-		v := b.Get([]byte(key))
-		if v == nil {
-			err = berror.DbNotFound
-			return err
-		}
-		err = json.Unmarshal(v, &result)
+		var b *bolt.Bucket
+		b, err = tx.CreateBucketIfNotExists([]byte(bucket))
 		if err != nil {
+			log.Error("Can't create bucket:", bucket, err.Error())
 			return err
 		}
+		result = M{}
+		if v := b.Get([]byte(key)); v != nil {
+			err = json.Unmarshal(v, &result)
+			if err != nil {
+				return err
+			}
+		}
 		props := strings.Split(propPath, ".")
 		if len(props) == 1 {
 			_val, ok := result[propPath]
@@ -203,6 +205,29 @@ func (DB) Get(bucket, key string) (result []byte, err error) {
 	return
 }
 
+// Pop returns value by key and deletes it from bucket in one transaction
+func (DB) Pop(bucket, key string) (result []byte, err error) {
+	BoltDB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			err = berror.DbNotFound
+			return err
+		}
+		v := b.Get([]byte(key))
+
+		if v == nil {
+			err = berror.DbNotFound
+			return err
+		}
+		result = make([]byte, len(v))
+		copy(result, v)
+
+		err = b.Delete([]byte(key))
+		return err
+	})
+	return
+}
+
 func (DB) GetUnmarshalled(bucket, key string) (result M, err error) {
 	BoltDB.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(bucket))
@@ -334,6 +359,20 @@ func (DB) GetAllKeysByPrefix(bucket, _prefix string) (data []string, err error)
 	return
 }
 
+// GetAllBucketsByPrefix returns names of all root buckets starting with prefix
+func (DB) GetAllBucketsByPrefix(prefix string) (data []string, err error) {
+	data = []string{}
+	err = BoltDB.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			if strings.HasPrefix(string(name), prefix) {
+				data = append(data, string(name))
+			}
+			return nil
+		})
+	})
+	return
+}
+
 func (DB) GetFromNested(bucket, nestedBucket, key string) (result M, err error) {
 	BoltDB.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(bucket))
@@ -381,6 +420,47 @@ func (DB) GetNextSequenceForBucket(bucket string, subBucket *string) (sequence i
 	return int(_sequence), err
 }
 
+// NextSequenceForKey increments integer sequence stored by key and returns its new value
+func (DB) NextSequenceForKey(bucket, key string) (sequence int, err error) {
+	BoltDB.Update(func(tx *bolt.Tx) error {
+		var b *bolt.Bucket
+		b, err = tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			log.Error("Can't create bucket:", bucket, err.Error())
+			return err
+		}
+		if v := b.Get([]byte(key)); v != nil {
+			sequence, err = strconv.Atoi(string(v))
+			if err != nil {
+				err = berror.WrongData
+				return err
+			}
+		}
+		sequence++
+		err = b.Put([]byte(key), []byte(strconv.Itoa(sequence)))
+		return err
+	})
+	return
+}
+
+// GetSequenceForKey returns integer sequence stored by key or 0 if it is not exists
+func (DB) GetSequenceForKey(bucket, key string) (sequence int, err error) {
+	BoltDB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		if v := b.Get([]byte(key)); v != nil {
+			sequence, err = strconv.Atoi(string(v))
+			if err != nil {
+				err = berror.WrongData
+			}
+		}
+		return err
+	})
+	return
+}
+
 func (DB) Count(bucket string) (count int) {
 	BoltDB.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(bucket))
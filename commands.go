@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/getblank/blank-sr/sessionstore"
+)
+
+const sessionsUsage = `Usage: blank-sr sessions <command> [flags]
+
+Commands:
+  export    writes sessions as JSON lines
+  import    reads sessions as JSON lines
+
+blank-sr must be stopped while running these commands, because blank.db is locked by the running process.
+`
+
+// sessionsCommand runs "blank-sr sessions" subcommands
+func sessionsCommand(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, sessionsUsage)
+		return ErrInvalidArguments
+	}
+
+	switch args[0] {
+	case "export":
+		return sessionsExportCommand(args[1:])
+	case "import":
+		return sessionsImportCommand(args[1:])
+	}
+
+	fmt.Fprint(os.Stderr, sessionsUsage)
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+func sessionsExportCommand(args []string) error {
+	fs := flag.NewFlagSet("sessions export", flag.ExitOnError)
+	userID := fs.String("user", "", "export only sessions of the user")
+	maxAge := fs.Duration("max-age", 0, "export only sessions created within duration, e.g. 24h")
+	file := fs.String("file", "", "file to write sessions to, stdout if not set")
+	fs.Parse(args)
+
+	if err := sessionstore.LoadEncryptionKeys(); err != nil {
+		return fmt.Errorf("can't load sessions encryption keys: %v", err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *file != "" {
+		f, err := os.Create(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	filter := sessionstore.ExportFilter{MaxAge: *maxAge}
+	if *userID != "" {
+		filter.UserID = *userID
+	}
+
+	count, err := sessionstore.Export(w, filter)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d sessions\n", count)
+
+	return nil
+}
+
+func sessionsImportCommand(args []string) error {
+	fs := flag.NewFlagSet("sessions import", flag.ExitOnError)
+	file := fs.String("file", "", "file to read sessions from, stdin if not set")
+	fs.Parse(args)
+
+	if err := sessionstore.LoadKeys(); err != nil {
+		return fmt.Errorf("can't load RSA keys, copy keys dir from the source host first: %v", err)
+	}
+
+	if err := sessionstore.LoadEncryptionKeys(); err != nil {
+		return fmt.Errorf("can't load sessions encryption keys: %v", err)
+	}
+
+	var r io.Reader = os.Stdin
+	if *file != "" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	imported, skipped, err := sessionstore.Import(r)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Imported %d sessions, skipped %d\n", imported, skipped)
+
+	return nil
+}
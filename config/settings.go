@@ -2,6 +2,7 @@ package config
 
 import (
 	"errors"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -16,6 +17,8 @@ var (
 
 	// ErrInvalidTTLFormat represents ttl format error
 	ErrInvalidTTLFormat = errors.New("invalid ttl in config")
+	// ErrInvalidIssuerURL represents issuer URL format error
+	ErrInvalidIssuerURL = errors.New("issuer url in config must be https url")
 )
 
 // JWTTTL returns TTL for JWT tokens
@@ -29,7 +32,137 @@ func JWTTTL() (time.Duration, error) {
 	if serverSettings.jwtTTL != nil {
 		return *serverSettings.jwtTTL, nil
 	}
-	ttlStrings := strings.Split(serverSettings.JWTTTL, ":")
+	res, err := parseTTL(serverSettings.JWTTTL)
+	if err != nil {
+		return 0, err
+	}
+	serverSettings.jwtTTL = &res
+	return res, nil
+}
+
+// ImpersonationTTL returns TTL for sessions created by impersonation
+func ImpersonationTTL() (time.Duration, error) {
+	confLocker.RLock()
+	defer confLocker.RUnlock()
+	// For testing purpose
+	if serverSettings == nil {
+		return time.Hour, nil
+	}
+
+	return parseTTL(serverSettings.ImpersonationTTL)
+}
+
+// MFAPendingTTL returns TTL for sessions waiting for the second authentication factor
+func MFAPendingTTL() (time.Duration, error) {
+	confLocker.RLock()
+	defer confLocker.RUnlock()
+	// For testing purpose
+	if serverSettings == nil {
+		return time.Minute * 5, nil
+	}
+
+	return parseTTL(serverSettings.MFAPendingTTL)
+}
+
+// RegisterTokenTTL returns TTL for registration and activation tokens
+func RegisterTokenTTL() (time.Duration, error) {
+	confLocker.RLock()
+	defer confLocker.RUnlock()
+	// For testing purpose
+	if serverSettings == nil {
+		return time.Hour, nil
+	}
+
+	return parseTTL(serverSettings.RegisterTokenExpiration)
+}
+
+// PasswordResetTokenTTL returns TTL for password reset tokens
+func PasswordResetTokenTTL() (time.Duration, error) {
+	confLocker.RLock()
+	defer confLocker.RUnlock()
+	// For testing purpose
+	if serverSettings == nil {
+		return time.Hour, nil
+	}
+
+	return parseTTL(serverSettings.PasswordResetTokenExpiration)
+}
+
+// SSOCodeTTL returns TTL for SSO one-time codes
+func SSOCodeTTL() (time.Duration, error) {
+	confLocker.RLock()
+	defer confLocker.RUnlock()
+	// For testing purpose
+	if serverSettings == nil {
+		return time.Minute, nil
+	}
+
+	return parseTTL(serverSettings.SSOCodeTTL)
+}
+
+// IssuerURL returns https URL used as "iss" claim of JWT or empty string if it is not set
+func IssuerURL() (string, error) {
+	confLocker.RLock()
+	defer confLocker.RUnlock()
+	if serverSettings == nil || serverSettings.IssuerURL == "" {
+		return "", nil
+	}
+
+	u, err := url.Parse(serverSettings.IssuerURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return "", ErrInvalidIssuerURL
+	}
+
+	return serverSettings.IssuerURL, nil
+}
+
+// SSOOrigins returns origins allowed for SSO
+func SSOOrigins() []string {
+	confLocker.RLock()
+	defer confLocker.RUnlock()
+	if serverSettings == nil {
+		return nil
+	}
+
+	return serverSettings.SSOOrigins
+}
+
+// RateLimit modes
+const (
+	RateLimitTokenBucket   = "tokenBucket"
+	RateLimitSlidingWindow = "slidingWindow"
+)
+
+// RateLimit describes named limit for ratelimit.take.
+// Token bucket holds up to Limit tokens and refills Limit tokens every Period seconds.
+// Sliding window allows no more than Limit cost during any Period seconds.
+type RateLimit struct {
+	Mode    string  `json:"mode,omitempty"` // tokenBucket if empty
+	Limit   float64 `json:"limit"`
+	Period  float64 `json:"period"`
+	Persist bool    `json:"persist,omitempty"`
+}
+
+// PeriodDuration returns Period as time.Duration
+func (r RateLimit) PeriodDuration() time.Duration {
+	return time.Duration(r.Period * float64(time.Second))
+}
+
+// RateLimitByName returns rate limit definition from rateLimits section of server settings
+func RateLimitByName(name string) (RateLimit, bool) {
+	confLocker.RLock()
+	defer confLocker.RUnlock()
+	if serverSettings == nil {
+		return RateLimit{}, false
+	}
+	r, ok := serverSettings.RateLimits[name]
+
+	return r, ok
+}
+
+// parseTTL parses TTL in "hours:minutes" format
+func parseTTL(ttl string) (time.Duration, error) {
+	ttlStrings := strings.Split(ttl, ":")
 	if len(ttlStrings) == 0 {
 		return 0, ErrInvalidTTLFormat
 	}
@@ -38,15 +171,14 @@ func JWTTTL() (time.Duration, error) {
 		return 0, err
 	}
 	res := time.Hour * time.Duration(hours)
-	var minutes int
 	if len(ttlStrings) > 1 {
-		minutes, err = strconv.Atoi(ttlStrings[1])
+		minutes, err := strconv.Atoi(ttlStrings[1])
 		if err != nil {
 			return 0, err
 		}
 		res = res + time.Minute*time.Duration(minutes)
 	}
-	serverSettings.jwtTTL = &res
+
 	return res, nil
 }
 
@@ -74,19 +206,24 @@ type commonSettingsStruct struct {
 }
 
 type serverSettingsStruct struct {
-	RegisterTokenExpiration           string         `json:"registerTokenExpiration,omitempty"`
-	PasswordResetTokenExpiration      string         `json:"passwordResetTokenExpiration,omitempty"`
-	ActivationEmailTemplate           string         `json:"activationEmailTemplate,omitempty"`
-	PasswordResetEmailTemplate        string         `json:"passwordResetEmailTemplate,omitempty"`
-	PasswordResetSuccessEmailTemplate string         `json:"passwordResetSuccessEmailTemplate,omitempty"`
-	RegistrationSuccessEmailTemplate  string         `json:"registrationSuccessEmailTemplate,omitempty"`
-	ActivationSuccessPage             string         `json:"activationSuccessPage,omitempty"`
-	ActivationErrorPage               string         `json:"activationErrorPage,omitempty"`
-	MaxLogSize                        int            `json:"maxLogSize,omitempty"`
-	Port                              string         `json:"port,omitempty"`
-	SSOOrigins                        []string       `json:"ssoOrigins,omitempty"`
-	JWTTTL                            string         `json:"jwtTtl,omitempty"`
-	Auth                              *authLifeCycle `json:"auth,omitempty"`
+	RegisterTokenExpiration           string               `json:"registerTokenExpiration,omitempty"`
+	PasswordResetTokenExpiration      string               `json:"passwordResetTokenExpiration,omitempty"`
+	ActivationEmailTemplate           string               `json:"activationEmailTemplate,omitempty"`
+	PasswordResetEmailTemplate        string               `json:"passwordResetEmailTemplate,omitempty"`
+	PasswordResetSuccessEmailTemplate string               `json:"passwordResetSuccessEmailTemplate,omitempty"`
+	RegistrationSuccessEmailTemplate  string               `json:"registrationSuccessEmailTemplate,omitempty"`
+	ActivationSuccessPage             string               `json:"activationSuccessPage,omitempty"`
+	ActivationErrorPage               string               `json:"activationErrorPage,omitempty"`
+	MaxLogSize                        int                  `json:"maxLogSize,omitempty"`
+	Port                              string               `json:"port,omitempty"`
+	IssuerURL                         string               `json:"issuerUrl,omitempty"`
+	SSOOrigins                        []string             `json:"ssoOrigins,omitempty"`
+	SSOCodeTTL                        string               `json:"ssoCodeTtl,omitempty"`
+	JWTTTL                            string               `json:"jwtTtl,omitempty"`
+	ImpersonationTTL                  string               `json:"impersonationTtl,omitempty"`
+	MFAPendingTTL                     string               `json:"mfaPendingTtl,omitempty"`
+	RateLimits                        map[string]RateLimit `json:"rateLimits,omitempty"`
+	Auth                              *authLifeCycle       `json:"auth,omitempty"`
 	jwtTTL                            *time.Duration
 }
 
@@ -119,5 +256,8 @@ func makeDefaultSettings() {
 		MaxLogSize:                        1000,
 		Port:                              "3001",
 		JWTTTL:                            "24:00",
+		ImpersonationTTL:                  "1:00",
+		MFAPendingTTL:                     "0:05",
+		SSOCodeTTL:                        "0:01",
 	}
 }
@@ -0,0 +1,56 @@
+package counters
+
+import (
+	"github.com/getblank/blank-sr/bdb"
+	"github.com/getblank/blank-sr/berror"
+)
+
+const valueProp = "value"
+
+var (
+	countersBucket  = "__counters"
+	sequencesBucket = "__sequences"
+	db              = bdb.DB{}
+)
+
+// Inc adds delta to the counter in one transaction and returns its new value. New counter starts from 0.
+func Inc(name string, delta int) (int, error) {
+	res, err := db.Inc(countersBucket, name, valueProp, float64(delta))
+	if err != nil {
+		return 0, err
+	}
+
+	return value(res)
+}
+
+// Get returns value of the counter or 0 if it is not exists
+func Get(name string) (int, error) {
+	res, err := db.GetUnmarshalled(countersBucket, name)
+	if err == berror.DbNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return value(res)
+}
+
+// Set sets value of the counter
+func Set(name string, value int) error {
+	return db.Save(countersBucket, name, bdb.M{valueProp: value})
+}
+
+// Next returns next value of the sequence. Sequence starts from 1 and never returns the same value twice.
+func Next(name string) (int, error) {
+	return db.GetNextSequenceForBucket(sequencesBucket, &name)
+}
+
+func value(res bdb.M) (int, error) {
+	v, ok := res[valueProp].(float64)
+	if !ok {
+		return 0, berror.WrongData
+	}
+
+	return int(v), nil
+}
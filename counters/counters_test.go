@@ -0,0 +1,70 @@
+package counters
+
+import (
+	"sync"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestCounters(t *testing.T) {
+	g := Goblin(t)
+	g.Describe("Counters", func() {
+		g.Before(func() {
+			db.DeleteBucket(countersBucket)
+			db.DeleteBucket(sequencesBucket)
+		})
+
+		g.Describe("#Inc", func() {
+			g.It("should add delta to the counter", func() {
+				v, err := Inc("visits", 5)
+				g.Assert(err == nil).IsTrue()
+				g.Assert(v).Equal(5)
+				v, _ = Inc("visits", -2)
+				g.Assert(v).Equal(3)
+				v, _ = Get("visits")
+				g.Assert(v).Equal(3)
+			})
+			g.It("should be atomic", func() {
+				var wg sync.WaitGroup
+				for i := 0; i < 50; i++ {
+					wg.Add(1)
+					go func() {
+						Inc("concurrent", 1)
+						wg.Done()
+					}()
+				}
+				wg.Wait()
+				v, _ := Get("concurrent")
+				g.Assert(v).Equal(50)
+			})
+		})
+
+		g.Describe("#Set", func() {
+			g.It("should set value of the counter", func() {
+				g.Assert(Set("visits", 100)).Equal(nil)
+				v, _ := Inc("visits", 1)
+				g.Assert(v).Equal(101)
+			})
+		})
+
+		g.Describe("#Get", func() {
+			g.It("should return 0 for not existing counter", func() {
+				v, err := Get("unknown")
+				g.Assert(err == nil).IsTrue()
+				g.Assert(v).Equal(0)
+			})
+		})
+
+		g.Describe("#Next", func() {
+			g.It("should return increasing values starting from 1", func() {
+				v, _ := Next("invoices")
+				g.Assert(v).Equal(1)
+				v, _ = Next("invoices")
+				g.Assert(v).Equal(2)
+				v, _ = Next("orders")
+				g.Assert(v).Equal(1)
+			})
+		})
+	})
+}
@@ -0,0 +1,261 @@
+package election
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/getblank/blank-sr/bdb"
+)
+
+var (
+	elections        = map[string]*election{}
+	owners2Elections = map[string]map[string]struct{}{}
+	locker           sync.Mutex
+	// leaders is the snapshot of current leaders, so CurrentLeader never waits for locker.
+	// Election without leader has Leader with empty Owner and the term of the change.
+	leaders        = map[string]Leader{}
+	leadersLocker  sync.RWMutex
+	changeHandlers = []func(name string, term int, leader *Leader){}
+	handlersLocker sync.RWMutex
+	changes        = make(chan change, 1024)
+
+	termsBucket = "__electionTerms"
+	db          = bdb.DB{}
+
+	// ErrNotCandidate returns when owner does not campaign in the election
+	ErrNotCandidate = errors.New("not a candidate")
+)
+
+// Leader describes elected leader. Term increases on every election, even across restarts,
+// so it can be used as a fencing token.
+type Leader struct {
+	Owner string    `json:"owner"`
+	Term  int       `json:"term"`
+	Since time.Time `json:"since"`
+}
+
+type election struct {
+	leader     *Leader
+	candidates []*candidate // the first candidate is the leader
+	lease      *time.Timer
+}
+
+type candidate struct {
+	owner string
+	lease time.Duration
+}
+
+type change struct {
+	name   string
+	term   int
+	leader *Leader
+}
+
+// Init is the entrypoint of election. It starts delivery of leader changes to OnChange callbacks.
+func Init() {
+	go dispatcher()
+}
+
+// Campaign adds owner to the candidates of the election with provided name. Candidates are elected in the order they joined.
+// If lease is not 0, leader must campaign again before lease expires, otherwise it is removed from the election.
+// Campaign of the current leader renews its lease. Returns current leader.
+func Campaign(owner, name string, lease time.Duration) *Leader {
+	locker.Lock()
+	defer locker.Unlock()
+	e, ok := elections[name]
+	if !ok {
+		e = new(election)
+		elections[name] = e
+	}
+
+	if i := e.indexOf(owner); i >= 0 {
+		e.candidates[i].lease = lease
+		if e.leader.Owner == owner {
+			e.startLease(name, lease)
+		}
+		return e.currentLeader()
+	}
+
+	e.candidates = append(e.candidates, &candidate{owner: owner, lease: lease})
+	if _, ok := owners2Elections[owner]; !ok {
+		owners2Elections[owner] = map[string]struct{}{}
+	}
+	owners2Elections[owner][name] = struct{}{}
+	log.Debugf("election %s: owner %s CAMPAIGNS", name, owner)
+	if e.leader == nil {
+		elect(name, e)
+	}
+
+	return e.currentLeader()
+}
+
+// Resign removes owner from the candidates of the election. If owner is the leader, the next candidate is elected.
+func Resign(owner, name string) error {
+	locker.Lock()
+	defer locker.Unlock()
+	e, ok := elections[name]
+	if !ok || e.indexOf(owner) < 0 {
+		return ErrNotCandidate
+	}
+	resign(owner, name, e)
+
+	return nil
+}
+
+// ResignAll removes owner from all elections it campaigns in
+func ResignAll(owner string) {
+	locker.Lock()
+	defer locker.Unlock()
+	for name := range owners2Elections[owner] {
+		if e, ok := elections[name]; ok {
+			resign(owner, name, e)
+		}
+	}
+}
+
+// CurrentLeader returns leader of the election with provided name or nil if there is no leader
+func CurrentLeader(name string) *Leader {
+	leadersLocker.RLock()
+	defer leadersLocker.RUnlock()
+	l, ok := leaders[name]
+	if !ok || l.Owner == "" {
+		return nil
+	}
+
+	return &l
+}
+
+// Current returns term of the last leader change and the leader of the election with provided name.
+// leader is nil if there is no leader, term is 0 if election never had a leader.
+func Current(name string) (term int, leader *Leader) {
+	leadersLocker.RLock()
+	defer leadersLocker.RUnlock()
+	l, ok := leaders[name]
+	if !ok || l.Owner == "" {
+		return l.Term, nil
+	}
+
+	return l.Term, &l
+}
+
+// OnChange registers callback that will called when leader of the election changes.
+// leader is nil when election has no candidates left, term is increased on every change including this one.
+// Callbacks are called one by one in the order of changes, not under election lock. Init must be called before.
+func OnChange(fn func(name string, term int, leader *Leader)) {
+	handlersLocker.Lock()
+	defer handlersLocker.Unlock()
+	changeHandlers = append(changeHandlers, fn)
+}
+
+// resign removes owner from candidates and elects the next candidate if owner was the leader. locker must be locked.
+func resign(owner, name string, e *election) {
+	i := e.indexOf(owner)
+	if i < 0 {
+		return
+	}
+	e.candidates = append(e.candidates[:i], e.candidates[i+1:]...)
+	delete(owners2Elections[owner], name)
+	if len(owners2Elections[owner]) == 0 {
+		delete(owners2Elections, owner)
+	}
+	log.Debugf("election %s: owner %s RESIGNED", name, owner)
+
+	if i == 0 {
+		elect(name, e)
+	}
+}
+
+// elect makes the first candidate the leader. locker must be locked.
+func elect(name string, e *election) {
+	e.stopLease()
+	term, err := db.NextSequenceForKey(termsBucket, name)
+	if err != nil {
+		log.WithError(err).Errorf("Can't get term for election %s", name)
+	}
+	if len(e.candidates) == 0 {
+		e.leader = nil
+		delete(elections, name)
+		leadersLocker.Lock()
+		leaders[name] = Leader{Term: term}
+		leadersLocker.Unlock()
+		log.Debugf("election %s: NO LEADER for term %d", name, term)
+		notify(name, term, nil)
+		return
+	}
+
+	c := e.candidates[0]
+	e.leader = &Leader{Owner: c.owner, Term: term, Since: time.Now()}
+	leadersLocker.Lock()
+	leaders[name] = *e.leader
+	leadersLocker.Unlock()
+	e.startLease(name, c.lease)
+	log.Debugf("election %s: owner %s ELECTED for term %d", name, c.owner, term)
+	notify(name, term, e.currentLeader())
+}
+
+// notify queues change for dispatcher, so changes are delivered in the order they happened. locker must be locked.
+func notify(name string, term int, leader *Leader) {
+	changes <- change{name: name, term: term, leader: leader}
+}
+
+func dispatcher() {
+	for c := range changes {
+		handlersLocker.RLock()
+		handlers := changeHandlers
+		handlersLocker.RUnlock()
+		for _, h := range handlers {
+			h(c.name, c.term, c.leader)
+		}
+	}
+}
+
+func expireLease(name string, e *election, leader *Leader) {
+	locker.Lock()
+	defer locker.Unlock()
+	if elections[name] != e || e.leader != leader {
+		return
+	}
+
+	log.Warnf("election %s: lease of the leader %s EXPIRED", name, leader.Owner)
+	resign(leader.Owner, name, e)
+}
+
+func (e *election) indexOf(owner string) int {
+	for i, c := range e.candidates {
+		if c.owner == owner {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (e *election) currentLeader() *Leader {
+	if e.leader == nil {
+		return nil
+	}
+	l := *e.leader
+
+	return &l
+}
+
+func (e *election) startLease(name string, lease time.Duration) {
+	e.stopLease()
+	if lease <= 0 {
+		return
+	}
+	leader := e.leader
+	e.lease = time.AfterFunc(lease, func() {
+		expireLease(name, e, leader)
+	})
+}
+
+func (e *election) stopLease() {
+	if e.lease != nil {
+		e.lease.Stop()
+		e.lease = nil
+	}
+}
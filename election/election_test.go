@@ -0,0 +1,100 @@
+package election
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+)
+
+func TestElection(t *testing.T) {
+	g := Goblin(t)
+	Init()
+
+	g.Describe("#Campaign", func() {
+		g.It("should elect the first candidate", func() {
+			l := Campaign("124", "cron", 0)
+			g.Assert(l.Owner).Equal("124")
+			l = Campaign("125", "cron", 0)
+			g.Assert(l.Owner).Equal("124")
+			g.Assert(CurrentLeader("cron").Owner).Equal("124")
+		})
+		g.It("should elect the next candidate with greater term when leader resigns", func() {
+			term := CurrentLeader("cron").Term
+			g.Assert(Resign("124", "cron")).Equal(nil)
+			l := CurrentLeader("cron")
+			g.Assert(l.Owner).Equal("125")
+			g.Assert(l.Term > term).IsTrue()
+			g.Assert(Resign("124", "cron")).Equal(ErrNotCandidate)
+			Resign("125", "cron")
+			g.Assert(CurrentLeader("cron") == nil).IsTrue()
+			g.Assert(elections["cron"] == nil).IsTrue()
+		})
+	})
+
+	g.Describe("#ResignAll", func() {
+		g.It("should remove disconnected owner from all elections", func() {
+			Campaign("224", "first", 0)
+			Campaign("224", "second", 0)
+			Campaign("225", "second", 0)
+			ResignAll("224")
+			g.Assert(CurrentLeader("first") == nil).IsTrue()
+			g.Assert(CurrentLeader("second").Owner).Equal("225")
+			g.Assert(owners2Elections["224"] == nil).IsTrue()
+			ResignAll("225")
+		})
+	})
+
+	g.Describe("#OnChange", func() {
+		g.It("should be called on every leader change in order", func() {
+			type event struct {
+				term   int
+				leader string
+			}
+			events := make(chan event, 3)
+			OnChange(func(name string, term int, leader *Leader) {
+				if name != "watched" {
+					return
+				}
+				e := event{term: term}
+				if leader != nil {
+					e.leader = leader.Owner
+				}
+				events <- e
+			})
+			Campaign("324", "watched", 0)
+			Campaign("325", "watched", 0)
+			Resign("324", "watched")
+			Resign("325", "watched")
+			var leaders []string
+			var lastTerm int
+			for i := 0; i < 3; i++ {
+				select {
+				case e := <-events:
+					g.Assert(e.term > lastTerm).IsTrue()
+					lastTerm = e.term
+					leaders = append(leaders, e.leader)
+				case <-time.After(time.Second):
+				}
+			}
+			g.Assert(leaders).Equal([]string{"324", "325", ""})
+			term, leader := Current("watched")
+			g.Assert(term).Equal(lastTerm)
+			g.Assert(leader == nil).IsTrue()
+		})
+	})
+
+	g.Describe("lease", func() {
+		g.It("should remove leader that did not renew lease", func() {
+			Campaign("424", "leased", time.Millisecond*20)
+			Campaign("425", "leased", 0)
+			time.Sleep(time.Millisecond * 10)
+			Campaign("424", "leased", time.Millisecond*20)
+			time.Sleep(time.Millisecond * 15)
+			g.Assert(CurrentLeader("leased").Owner).Equal("424")
+			time.Sleep(time.Millisecond * 20)
+			g.Assert(CurrentLeader("leased").Owner).Equal("425")
+			ResignAll("425")
+		})
+	})
+}
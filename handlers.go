@@ -1,14 +1,22 @@
 package main
 
 import (
+	"bytes"
 	"errors"
+	"math"
 	"strings"
+	"time"
 
+	"github.com/getblank/blank-sr/apikeys"
 	"github.com/getblank/blank-sr/config"
+	"github.com/getblank/blank-sr/counters"
+	"github.com/getblank/blank-sr/election"
 	"github.com/getblank/blank-sr/localstorage"
+	"github.com/getblank/blank-sr/ratelimit"
 	"github.com/getblank/blank-sr/registry"
 	"github.com/getblank/blank-sr/sessionstore"
 	"github.com/getblank/blank-sr/sync"
+	"github.com/getblank/blank-sr/tokenstore"
 	"github.com/getblank/wango"
 	log "github.com/sirupsen/logrus"
 )
@@ -107,6 +115,149 @@ func newSessionHandler(c *wango.Conn, uri string, args ...interface{}) (interfac
 	return sessionstore.New(user, sessionID).AccessToken, nil
 }
 
+// args must have 1 or 2 members
+// user map[string]interface{}, sessionID string (optional)
+// Creates session waiting for the second authentication factor.
+func newPendingSessionHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	if args == nil {
+		return nil, ErrInvalidArguments
+	}
+
+	user, ok := args[0].(map[string]interface{})
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+
+	var sessionID string
+	if len(args) > 1 {
+		if arg, ok := args[1].(string); ok {
+			sessionID = arg
+		} else {
+			log.Warnf("[newPendingSessionHandler] sessionID: '%v' is not a string", args[1])
+		}
+	}
+
+	return sessionstore.NewPending(user, sessionID).AccessToken, nil
+}
+
+// args must have 2 members
+// apiKey string, methods []interface{} of auth methods strings used
+// Returns new access token of the session.
+func elevateSessionHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, ErrInvalidArguments
+	}
+	apiKey, ok := args[0].(string)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+	_methods, ok := args[1].([]interface{})
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+	methods := make([]string, len(_methods))
+	for i, m := range _methods {
+		methods[i], ok = m.(string)
+		if !ok {
+			return nil, ErrInvalidArguments
+		}
+	}
+
+	s, err := sessionstore.Elevate(apiKey, methods)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.AccessToken, nil
+}
+
+// args must have 2 members
+// apiKey string of the admin session, user map[string]interface{} to impersonate
+// Caller must check that the user of admin session is allowed to impersonate.
+func impersonateSessionHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, ErrInvalidArguments
+	}
+	apiKey, ok := args[0].(string)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+	user, ok := args[1].(map[string]interface{})
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+
+	s, err := sessionstore.Impersonate(apiKey, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.AccessToken, nil
+}
+
+// args must have 1 member
+// apiKey string of the impersonated session
+// Returns access token of the admin session.
+func endImpersonationHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, ErrInvalidArguments
+	}
+	apiKey, ok := args[0].(string)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+
+	s, err := sessionstore.EndImpersonation(apiKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.AccessToken, nil
+}
+
+// args must have 2 members
+// apiKey, origin string
+// Returns one-time SSO code.
+func ssoCodeHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, ErrInvalidArguments
+	}
+	apiKey, ok := args[0].(string)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+	origin, ok := args[1].(string)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+
+	return sessionstore.NewSSOCode(apiKey, origin)
+}
+
+// args must have 2 members
+// code, origin string
+// Returns access token of the new session.
+func ssoExchangeHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, ErrInvalidArguments
+	}
+	code, ok := args[0].(string)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+	origin, ok := args[1].(string)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+
+	s, err := sessionstore.ExchangeSSOCode(code, origin, c.RemoteAddr())
+	if err != nil {
+		return nil, err
+	}
+
+	return s.AccessToken, nil
+}
+
 func checkSessionByAPIKeyHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
 	if args == nil {
 		return nil, ErrInvalidArguments
@@ -120,6 +271,9 @@ func checkSessionByAPIKeyHandler(c *wango.Conn, uri string, args ...interface{})
 	if err != nil {
 		return nil, err
 	}
+	if s.IsPending() {
+		return nil, sessionstore.ErrSessionPending
+	}
 	return s.GetUserID(), nil
 }
 
@@ -178,7 +332,7 @@ func sessionSubscribedHandler(c *wango.Conn, _uri string, args ...interface{}) (
 		return nil, ErrInvalidArguments
 	}
 	extra := args[3]
-	s.AddSubscription(connID, uri, extra)
+	s.AddSubscription(c.ID(), connID, uri, extra)
 
 	return nil, nil
 }
@@ -235,6 +389,83 @@ func sessionDeleteConnectionHandler(c *wango.Conn, uri string, args ...interface
 	return nil, nil
 }
 
+// args must have 1 member
+// ops []interface{} of {op, apiKey, connId, uri string, extra interface{}}
+// op is one of "subscribed", "unsubscribed", "delete-connection".
+// uri is required for "subscribed" and "unsubscribed" ops.
+func sessionBatchHandler(c *wango.Conn, _uri string, args ...interface{}) (interface{}, error) {
+	log.Debug("Session batch request", args)
+	if len(args) == 0 {
+		return nil, ErrInvalidArguments
+	}
+	list, ok := args[0].([]interface{})
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+
+	ops := make([]sessionstore.Op, len(list))
+	for i, _item := range list {
+		item, ok := _item.(map[string]interface{})
+		if !ok {
+			return nil, ErrInvalidArguments
+		}
+		typ, ok := item["op"].(string)
+		if !ok {
+			return nil, ErrInvalidArguments
+		}
+		apiKey, ok := item["apiKey"].(string)
+		if !ok {
+			return nil, ErrInvalidArguments
+		}
+		connID, ok := item["connId"].(string)
+		if !ok {
+			return nil, ErrInvalidArguments
+		}
+		uri, ok := item["uri"].(string)
+		if !ok && typ != sessionstore.OpDeleteConnection {
+			return nil, ErrInvalidArguments
+		}
+		ops[i] = sessionstore.Op{Type: typ, APIKey: apiKey, ConnID: connID, URI: uri, Extra: item["extra"]}
+	}
+
+	return nil, sessionstore.Batch(c.ID(), ops)
+}
+
+// args must have 1 member
+// connections []interface{} of {apiKey, connId string, subscriptions map[string]interface{}}
+// Replaces all connections held by the calling router with provided ones.
+// Returns apiKeys of sessions that were not found.
+func sessionSyncConnectionsHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	log.Debug("Session sync connections request", args)
+	if len(args) == 0 {
+		return nil, ErrInvalidArguments
+	}
+	list, ok := args[0].([]interface{})
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+
+	conns := map[string][]*sessionstore.Conn{}
+	for _, _item := range list {
+		item, ok := _item.(map[string]interface{})
+		if !ok {
+			return nil, ErrInvalidArguments
+		}
+		apiKey, ok := item["apiKey"].(string)
+		if !ok {
+			return nil, ErrInvalidArguments
+		}
+		connID, ok := item["connId"].(string)
+		if !ok {
+			return nil, ErrInvalidArguments
+		}
+		subscriptions, _ := item["subscriptions"].(map[string]interface{})
+		conns[apiKey] = append(conns[apiKey], &sessionstore.Conn{ConnID: connID, Subscriptions: subscriptions})
+	}
+
+	return sessionstore.SetConnectionsForOwner(c.ID(), conns), nil
+}
+
 // args must have 1 or 2 members
 // userID string, user interface{} (optional)
 func sessionUserUpdateHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
@@ -257,48 +488,167 @@ func sessionUserUpdateHandler(c *wango.Conn, uri string, args ...interface{}) (i
 	return nil, nil
 }
 
+// args may have 1 member
+// filter map[string]interface{} {userId string, maxAge float64 in seconds} (optional)
+// Returns sessions as JSON lines.
+func sessionExportHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	var filter sessionstore.ExportFilter
+	if len(args) > 0 && args[0] != nil {
+		f, ok := args[0].(map[string]interface{})
+		if !ok {
+			return nil, ErrInvalidArguments
+		}
+		if userID, ok := f["userId"].(string); ok {
+			filter.UserID = userID
+		}
+		if maxAge, ok := f["maxAge"].(float64); ok {
+			filter.MaxAge = time.Duration(maxAge * float64(time.Second))
+		}
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if _, err := sessionstore.Export(buf, filter); err != nil {
+		return nil, err
+	}
+
+	return buf.String(), nil
+}
+
+// args must have 1 member
+// data string with sessions as JSON lines
+func sessionImportHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, ErrInvalidArguments
+	}
+	data, ok := args[0].(string)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+
+	imported, skipped, err := sessionstore.Import(strings.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]int{"imported": imported, "skipped": skipped}, nil
+}
+
 func subSessionsHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
 	all := sessionstore.GetAll()
 	return map[string]interface{}{"event": "init", "data": all}, nil
 }
 
-func syncLockHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
-	if len(args) == 0 {
+// args must have 2 or 3 members
+// purpose, userID string, data interface{} (optional)
+// Returns token string.
+func tokenIssueHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	if len(args) < 2 {
 		return nil, ErrInvalidArguments
 	}
-	id, ok := args[0].(string)
+	purpose, ok := args[0].(string)
 	if !ok {
 		return nil, ErrInvalidArguments
 	}
-	sync.Lock(c.ID(), id)
-	return nil, nil
+	// sso codes must pass session.sso-code and session.sso-exchange checks
+	if purpose == tokenstore.PurposeSSO {
+		return nil, tokenstore.ErrUnknownPurpose
+	}
+	userID, ok := args[1].(string)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+	var data interface{}
+	if len(args) > 2 {
+		data = args[2]
+	}
+
+	t, err := tokenstore.Issue(purpose, userID, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.Token, nil
 }
 
-func syncUnlockHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
-	if len(args) == 0 {
+// args must have 2 members
+// purpose, token string
+func tokenConsumeHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	if len(args) < 2 {
 		return nil, ErrInvalidArguments
 	}
-	id, ok := args[0].(string)
+	purpose, ok := args[0].(string)
 	if !ok {
 		return nil, ErrInvalidArguments
 	}
-	sync.Unlock(c.ID(), id)
-	return nil, nil
+	// sso codes must pass session.sso-code and session.sso-exchange checks
+	if purpose == tokenstore.PurposeSSO {
+		return nil, tokenstore.ErrUnknownPurpose
+	}
+	token, ok := args[1].(string)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+
+	return tokenstore.Consume(purpose, token)
 }
 
-func syncOnceHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
-	if len(args) == 0 {
+// args must have 3 or 4 members
+// name, userID string, scopes []interface{} of strings, ttl float64 in seconds (optional)
+// Returns plain key and its description. Plain key can't be restored later.
+func apiKeyCreateHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	if len(args) < 3 {
 		return nil, ErrInvalidArguments
 	}
-	id, ok := args[0].(string)
+	name, ok := args[0].(string)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+	userID, ok := args[1].(string)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+	_scopes, ok := args[2].([]interface{})
 	if !ok {
 		return nil, ErrInvalidArguments
 	}
+	scopes := make([]string, len(_scopes))
+	for i, s := range _scopes {
+		scopes[i], ok = s.(string)
+		if !ok {
+			return nil, ErrInvalidArguments
+		}
+	}
+	ttl, err := secondsArg(args, 3)
+	if err != nil {
+		return nil, err
+	}
+
+	plain, k, err := apikeys.Create(name, userID, scopes, ttl)
+	if err != nil {
+		return nil, err
+	}
 
-	return nil, sync.Once(id)
+	return map[string]interface{}{"key": plain, "apiKey": k}, nil
 }
 
-func localStorageGetItemHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+// args may have 1 member
+// userID string (optional). Returns keys of all users if not provided.
+func apiKeyListHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	var userID interface{}
+	if len(args) > 0 && args[0] != nil {
+		id, ok := args[0].(string)
+		if !ok {
+			return nil, ErrInvalidArguments
+		}
+		userID = id
+	}
+
+	return apikeys.List(userID)
+}
+
+// args must have 1 member
+// id string
+func apiKeyRevokeHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
 	if len(args) == 0 {
 		return nil, ErrInvalidArguments
 	}
@@ -307,26 +657,73 @@ func localStorageGetItemHandler(c *wango.Conn, uri string, args ...interface{})
 		return nil, ErrInvalidArguments
 	}
 
-	return localstorage.GetItem(id), nil
+	return nil, apikeys.Revoke(id)
 }
 
-func localStorageSetItemHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
-	if len(args) < 2 {
+// args must have 1 or 2 members
+// key string, scope string (optional)
+func apiKeyVerifyHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	if len(args) == 0 {
 		return nil, ErrInvalidArguments
 	}
-	id, ok := args[0].(string)
+	key, ok := args[0].(string)
 	if !ok {
 		return nil, ErrInvalidArguments
 	}
-	item, ok := args[1].(string)
+	var scope string
+	if len(args) > 1 {
+		scope, ok = args[1].(string)
+		if !ok {
+			return nil, ErrInvalidArguments
+		}
+	}
+
+	return apikeys.Verify(key, scope)
+}
+
+// args must have 1 to 3 members
+// id string, timeout float64 in seconds (optional), lease float64 in seconds (optional)
+// Lock with lease is released automatically if it is not renewed in time.
+// Returns fencing token of the lock.
+func syncLockHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, ErrInvalidArguments
+	}
+	id, ok := args[0].(string)
 	if !ok {
 		return nil, ErrInvalidArguments
 	}
+	timeout, err := secondsArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	lease, err := secondsArg(args, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	if timeout > 0 {
+		err = sync.LockWithTimeout(c.ID(), id, timeout)
+	} else {
+		err = sync.Lock(c.ID(), id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if lease > 0 {
+		if err := sync.Renew(c.ID(), id, lease); err != nil {
+			return nil, err
+		}
+	}
 
-	return localstorage.SetItem(id, item), nil
+	return sync.FencingToken(c.ID(), id)
 }
 
-func localStorageRemoveItemHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+// args must have 1 or 2 members
+// id string, lease float64 in seconds (optional)
+// Returns fencing token if lock acquired or false otherwise.
+func syncTryLockHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
 	if len(args) == 0 {
 		return nil, ErrInvalidArguments
 	}
@@ -334,11 +731,641 @@ func localStorageRemoveItemHandler(c *wango.Conn, uri string, args ...interface{
 	if !ok {
 		return nil, ErrInvalidArguments
 	}
-	localstorage.RemoveItem(id)
-	return nil, nil
+	lease, err := secondsArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if !sync.TryLock(c.ID(), id) {
+		return false, nil
+	}
+
+	if lease > 0 {
+		if err := sync.Renew(c.ID(), id, lease); err != nil {
+			return nil, err
+		}
+	}
+
+	return sync.FencingToken(c.ID(), id)
 }
 
-func localStorageClearHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
-	localstorage.Clear()
-	return nil, nil
+// args must have 2 members
+// id string, token float64
+// Returns error if token is older than the last one issued for the lock.
+func syncValidateHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, ErrInvalidArguments
+	}
+	id, ok := args[0].(string)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+	token, ok := args[1].(float64)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+
+	return nil, sync.Validate(id, int(token))
+}
+
+// args must have 2 members
+// id string, lease float64 in seconds
+func syncRenewHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, ErrInvalidArguments
+	}
+	id, ok := args[0].(string)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+	lease, err := secondsArg(args, 1)
+	if err != nil || lease <= 0 {
+		return nil, ErrInvalidArguments
+	}
+
+	return nil, sync.Renew(c.ID(), id, lease)
+}
+
+func syncUnlockHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, ErrInvalidArguments
+	}
+	id, ok := args[0].(string)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+	sync.Unlock(c.ID(), id)
+	return nil, nil
+}
+
+// args must have 1 member
+// id string
+// Read lock is shared with other readers and waits while any owner holds or waits for sync.lock of the same id.
+func syncRLockHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, ErrInvalidArguments
+	}
+	id, ok := args[0].(string)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+
+	return nil, sync.RLock(c.ID(), id)
+}
+
+func syncRUnlockHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, ErrInvalidArguments
+	}
+	id, ok := args[0].(string)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+	sync.RUnlock(c.ID(), id)
+	return nil, nil
+}
+
+// args must have 3 or 4 members
+// id string, permits float64, max float64, timeout float64 in seconds (optional)
+// Permits are released on sync.release or when connection closed.
+func syncAcquireHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	if len(args) < 3 {
+		return nil, ErrInvalidArguments
+	}
+	id, ok := args[0].(string)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+	permits, ok := args[1].(float64)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+	max, ok := args[2].(float64)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+	timeout, err := secondsArg(args, 3)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, sync.Acquire(c.ID(), id, int(permits), int(max), timeout)
+}
+
+// args must have 1 or 2 members
+// id string, permits float64 (optional, all permits of the connection are released if not provided)
+func syncReleaseHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, ErrInvalidArguments
+	}
+	id, ok := args[0].(string)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+	var permits float64
+	if len(args) > 1 && args[1] != nil {
+		permits, ok = args[1].(float64)
+		if !ok {
+			return nil, ErrInvalidArguments
+		}
+	}
+
+	return nil, sync.Release(c.ID(), id, int(permits))
+}
+
+// Returns all locks with their holders, readers and waiters, and registry identities of the owners.
+func syncInspectHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	return inspectLocks(), nil
+}
+
+// args must have 1 or 2 members
+// id string, reason string (optional)
+// Releases lock held by any connection. Release is written to the audit log.
+func syncForceReleaseHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, ErrInvalidArguments
+	}
+	id, ok := args[0].(string)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+	var reason string
+	if len(args) > 1 && args[1] != nil {
+		reason, ok = args[1].(string)
+		if !ok {
+			return nil, ErrInvalidArguments
+		}
+	}
+
+	by := c.ID()
+	if s, ok := registry.GetByConnID(by); ok {
+		by = s.Type + "@" + s.Address + "/" + by
+	}
+
+	return nil, sync.ForceRelease(id, by, reason)
+}
+
+// args must have 1 to 3 members
+// id string, ttl float64 in seconds (optional, one minute if not provided), persist bool (optional)
+func syncOnceHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	id, ttl, persist, err := onceArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	if ttl == 0 {
+		if !persist {
+			return nil, sync.Once(id)
+		}
+		ttl = time.Minute
+	}
+
+	return nil, sync.OnceWithTTL(id, ttl, persist)
+}
+
+// args must have 1 to 3 members
+// id string, ttl float64 in seconds (optional, one minute if not provided), persist bool (optional)
+// Returns {"run": true} to the caller that must run the work and post result with sync.complete,
+// other callers get {"run": false, "result": result}.
+func syncDoHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	id, ttl, persist, err := onceArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	if ttl == 0 {
+		ttl = time.Minute
+	}
+
+	run, result, err := sync.Do(c.ID(), id, ttl, persist)
+	if err != nil {
+		return nil, err
+	}
+	if run {
+		return map[string]interface{}{"run": true}, nil
+	}
+
+	return map[string]interface{}{"run": false, "result": result}, nil
+}
+
+// args must have 2 members
+// id string, result interface{}
+func syncCompleteHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, ErrInvalidArguments
+	}
+	id, ok := args[0].(string)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+
+	return nil, sync.Complete(c.ID(), id, args[1])
+}
+
+func onceArgs(args []interface{}) (id string, ttl time.Duration, persist bool, err error) {
+	if len(args) == 0 {
+		return "", 0, false, ErrInvalidArguments
+	}
+	id, ok := args[0].(string)
+	if !ok {
+		return "", 0, false, ErrInvalidArguments
+	}
+	ttl, err = secondsArg(args, 1)
+	if err != nil {
+		return "", 0, false, err
+	}
+	if len(args) > 2 && args[2] != nil {
+		persist, ok = args[2].(bool)
+		if !ok {
+			return "", 0, false, ErrInvalidArguments
+		}
+	}
+
+	return id, ttl, persist, nil
+}
+
+// args must have 2 or 3 members
+// name string, parties float64, timeout float64 in seconds (optional)
+// Waits until parties connections arrive at the barrier.
+func syncBarrierHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, ErrInvalidArguments
+	}
+	name, ok := args[0].(string)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+	parties, ok := args[1].(float64)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+	timeout, err := secondsArg(args, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, sync.Barrier(c.ID(), name, int(parties), timeout)
+}
+
+// args must have 2 members
+// name string, count float64
+func latchCreateHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, ErrInvalidArguments
+	}
+	name, ok := args[0].(string)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+	count, ok := args[1].(float64)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+
+	return nil, sync.CreateLatch(name, int(count))
+}
+
+// args must have 1 member
+// name string
+func latchCountDownHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, ErrInvalidArguments
+	}
+	name, ok := args[0].(string)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+
+	return nil, sync.CountDown(c.ID(), name)
+}
+
+// args must have 1 or 2 members
+// name string, timeout float64 in seconds (optional)
+func latchAwaitHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, ErrInvalidArguments
+	}
+	name, ok := args[0].(string)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+	timeout, err := secondsArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, sync.AwaitLatch(c.ID(), name, timeout)
+}
+
+// args must have 1 or 2 members
+// name string, lease float64 in seconds (optional)
+// Leader with lease must campaign again before lease expires. Campaign of the leader renews its lease.
+// Returns {"elected": bool, "leader": leader}. Leader changes are published to the "election.<name>" topic.
+func electionCampaignHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, ErrInvalidArguments
+	}
+	name, ok := args[0].(string)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+	lease, err := secondsArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	leader := election.Campaign(c.ID(), name, lease)
+	return map[string]interface{}{"elected": leader.Owner == c.ID(), "leader": leader}, nil
+}
+
+// args must have 1 member
+// name string
+func electionResignHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, ErrInvalidArguments
+	}
+	name, ok := args[0].(string)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+
+	return nil, election.Resign(c.ID(), name)
+}
+
+// args must have 1 member
+// name string
+func electionLeaderHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, ErrInvalidArguments
+	}
+	name, ok := args[0].(string)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+
+	return leaderEvent(election.Current(name)), nil
+}
+
+// subElectionHandler returns current leader to the subscriber of the "election.<name>" topic
+func subElectionHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	name := strings.TrimPrefix(uri, electionTopicPrefix)
+	return leaderEvent(election.Current(name)), nil
+}
+
+// leaderEvent returns leader with registry identity of its connection.
+// Term orders events, it is present even if there is no leader.
+func leaderEvent(term int, leader *election.Leader) map[string]interface{} {
+	event := map[string]interface{}{"leader": leader, "term": term}
+	if leader == nil {
+		return event
+	}
+	if s, ok := registry.GetByConnID(leader.Owner); ok {
+		event["service"] = s
+	}
+
+	return event
+}
+
+// args must have 1 or 2 members
+// key string, cost float64 (optional, 1 if not provided)
+// Key is "<limit>" or "<limit>:<subject>", where limit is defined in rateLimits section of server settings.
+// Returns {"allowed": bool, "remaining": float64, "retryAfter": float64 in seconds}.
+func rateLimitTakeHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, ErrInvalidArguments
+	}
+	key, ok := args[0].(string)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+	cost := float64(1)
+	if len(args) > 1 && args[1] != nil {
+		cost, ok = args[1].(float64)
+		if !ok {
+			return nil, ErrInvalidArguments
+		}
+	}
+
+	return ratelimit.Take(key, cost)
+}
+
+// args must have 1 or 2 members
+// name string, delta integer float64 (optional, 1 if not provided)
+// Returns new value of the counter.
+func counterIncHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, ErrInvalidArguments
+	}
+	name, ok := args[0].(string)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+	delta := float64(1)
+	if len(args) > 1 && args[1] != nil {
+		delta, ok = args[1].(float64)
+		if !ok || delta != math.Trunc(delta) {
+			return nil, ErrInvalidArguments
+		}
+	}
+
+	return counters.Inc(name, int(delta))
+}
+
+// args must have 1 member
+// name string
+func counterGetHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, ErrInvalidArguments
+	}
+	name, ok := args[0].(string)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+
+	return counters.Get(name)
+}
+
+// args must have 2 members
+// name string, value integer float64
+func counterSetHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, ErrInvalidArguments
+	}
+	name, ok := args[0].(string)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+	value, ok := args[1].(float64)
+	if !ok || value != math.Trunc(value) {
+		return nil, ErrInvalidArguments
+	}
+
+	return nil, counters.Set(name, int(value))
+}
+
+// args must have 1 member
+// name string
+// Returns next value of the sequence.
+func sequenceNextHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, ErrInvalidArguments
+	}
+	name, ok := args[0].(string)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+
+	return counters.Next(name)
+}
+
+// args must have 1 or 2 members
+// id string, namespace string (optional)
+func localStorageGetItemHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, ErrInvalidArguments
+	}
+	id, ok := args[0].(string)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+	ns, err := namespaceArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return ns.GetItem(id), nil
+}
+
+// args must have 1 or 2 members
+// ids []string, namespace string (optional)
+// Returns map of found items by their ids.
+func localStorageGetManyHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, ErrInvalidArguments
+	}
+	_ids, ok := args[0].([]interface{})
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+	ids := make([]string, len(_ids))
+	for i, _id := range _ids {
+		id, ok := _id.(string)
+		if !ok {
+			return nil, ErrInvalidArguments
+		}
+		ids[i] = id
+	}
+	ns, err := namespaceArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return ns.GetMany(ids), nil
+}
+
+// args must have 2 to 4 members
+// id string, item string, ttl float64 in seconds (optional), namespace string (optional)
+func localStorageSetItemHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, ErrInvalidArguments
+	}
+	id, ok := args[0].(string)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+	item, ok := args[1].(string)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+	ttl, err := secondsArg(args, 2)
+	if err != nil {
+		return nil, err
+	}
+	ns, err := namespaceArg(args, 3)
+	if err != nil {
+		return nil, err
+	}
+
+	return ns.SetItemWithTTL(id, item, ttl), nil
+}
+
+// args must have 1 or 2 members
+// id string, namespace string (optional)
+func localStorageRemoveItemHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, ErrInvalidArguments
+	}
+	id, ok := args[0].(string)
+	if !ok {
+		return nil, ErrInvalidArguments
+	}
+	ns, err := namespaceArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	ns.RemoveItem(id)
+	return nil, nil
+}
+
+// args may have up to 2 members
+// prefix string (optional), namespace string (optional)
+// Returns ids of the items starting with prefix.
+func localStorageKeysHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	prefix, ns, err := prefixArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	return ns.Keys(prefix), nil
+}
+
+// args may have up to 2 members
+// prefix string (optional), namespace string (optional)
+// Removes items starting with prefix, or all items of the namespace if prefix is empty.
+func localStorageClearHandler(c *wango.Conn, uri string, args ...interface{}) (interface{}, error) {
+	prefix, ns, err := prefixArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	ns.Clear(prefix)
+	return nil, nil
+}
+
+func prefixArgs(args []interface{}) (string, localstorage.Namespace, error) {
+	var prefix string
+	if len(args) > 0 && args[0] != nil {
+		var ok bool
+		prefix, ok = args[0].(string)
+		if !ok {
+			return "", "", ErrInvalidArguments
+		}
+	}
+	ns, err := namespaceArg(args, 1)
+
+	return prefix, ns, err
+}
+
+// namespaceArg returns optional localStorage namespace argument, or the default namespace if it is not passed
+func namespaceArg(args []interface{}, i int) (localstorage.Namespace, error) {
+	if len(args) <= i || args[i] == nil {
+		return "", nil
+	}
+	ns, ok := args[i].(string)
+	if !ok || !localstorage.Namespace(ns).Valid() {
+		return "", ErrInvalidArguments
+	}
+
+	return localstorage.Namespace(ns), nil
+}
+
+// secondsArg returns optional duration argument passed in seconds, or 0 if it is not passed.
+// Negative durations are invalid.
+func secondsArg(args []interface{}, i int) (time.Duration, error) {
+	if len(args) <= i || args[i] == nil {
+		return 0, nil
+	}
+	seconds, ok := args[i].(float64)
+	if !ok || seconds < 0 {
+		return 0, ErrInvalidArguments
+	}
+
+	return time.Duration(seconds * float64(time.Second)), nil
 }
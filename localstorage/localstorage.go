@@ -1,6 +1,10 @@
 package localstorage
 
 import (
+	"strings"
+	"sync"
+	"time"
+
 	log "github.com/sirupsen/logrus"
 
 	"github.com/getblank/blank-sr/bdb"
@@ -9,26 +13,48 @@ import (
 var (
 	bucket = "_localStorage"
 	db     = bdb.DB{}
+	// locker prevents sweeper from deleting item that is set again concurrently
+	locker sync.Mutex
+)
+
+const (
+	ttlSuffix          = "__ttl"
+	namespaceSeparator = ":"
 )
 
-// GetItem returns item from localstorage or nil of it was not find
+// Namespace is the separate localStorage backed by its own bucket, so clearing one namespace
+// does not affect others. Empty namespace is the default localStorage.
+type Namespace string
+
+// Valid returns false if namespace name can be mixed up with other buckets
+func (ns Namespace) Valid() bool {
+	return !strings.Contains(string(ns), namespaceSeparator) && !strings.HasSuffix(string(ns), ttlSuffix)
+}
+
+// Init is the entrypoint of localstorage. It starts sweeper of expired items.
+func Init() {
+	go sweeper()
+}
+
+// GetItem returns item from localstorage or nil of it was not find or expired
 func GetItem(id string) interface{} {
-	item, err := db.Get(bucket, id)
-	if err != nil {
-		return nil
-	}
-	return string(item)
+	return Namespace("").GetItem(id)
 }
 
 // SetItem sets item in localStorage to provided value
 func SetItem(id, value string) error {
-	return db.Save(bucket, id, []byte(value))
+	return SetItemWithTTL(id, value, 0)
+}
+
+// SetItemWithTTL sets item in localStorage to provided value that expires after ttl.
+// Item never expires if ttl is 0.
+func SetItemWithTTL(id, value string, ttl time.Duration) error {
+	return Namespace("").SetItemWithTTL(id, value, ttl)
 }
 
 // RemoveItem removes item from localStorage
 func RemoveItem(id string) {
-	db.Delete(bucket, id)
-	return
+	Namespace("").RemoveItem(id)
 }
 
 // Clear removes all items from localStorage
@@ -37,10 +63,169 @@ func Clear() {
 }
 
 func clear() {
-	err := db.DeleteBucket(bucket)
+	Namespace("").Clear("")
+}
+
+// GetItem returns item from the namespace or nil of it was not find or expired
+func (ns Namespace) GetItem(id string) interface{} {
+	if expired(ns.bucket(), id, time.Now()) {
+		return nil
+	}
+	item, err := db.Get(ns.bucket(), id)
 	if err != nil {
-		if err.Error() != "bucket not found" {
-			log.WithError(err).Error("Can't clear localStorage")
+		return nil
+	}
+	return string(item)
+}
+
+// GetMany returns not expired items of the namespace by ids. Not found items are omitted.
+func (ns Namespace) GetMany(ids []string) map[string]interface{} {
+	result := map[string]interface{}{}
+	for _, id := range ids {
+		if item := ns.GetItem(id); item != nil {
+			result[id] = item
+		}
+	}
+
+	return result
+}
+
+// SetItemWithTTL sets item in the namespace to provided value that expires after ttl.
+// Item never expires if ttl is 0.
+func (ns Namespace) SetItemWithTTL(id, value string, ttl time.Duration) error {
+	locker.Lock()
+	defer locker.Unlock()
+	if ttl > 0 {
+		if err := db.Save(ttlBucket(ns.bucket()), id, time.Now().Add(ttl)); err != nil {
+			return err
 		}
+	} else {
+		db.Delete(ttlBucket(ns.bucket()), id)
+	}
+	return db.Save(ns.bucket(), id, []byte(value))
+}
+
+// RemoveItem removes item from the namespace
+func (ns Namespace) RemoveItem(id string) {
+	locker.Lock()
+	defer locker.Unlock()
+	db.Delete(ns.bucket(), id)
+	db.Delete(ttlBucket(ns.bucket()), id)
+}
+
+// Keys returns ids of not expired items of the namespace starting with prefix
+func (ns Namespace) Keys(prefix string) []string {
+	now := time.Now()
+	result := []string{}
+	for _, id := range keys(ns.bucket(), prefix) {
+		if !expired(ns.bucket(), id, now) {
+			result = append(result, id)
+		}
+	}
+
+	return result
+}
+
+// Clear removes items of the namespace starting with prefix. All items are removed if prefix is empty.
+func (ns Namespace) Clear(prefix string) {
+	locker.Lock()
+	defer locker.Unlock()
+	if prefix == "" {
+		for _, b := range []string{ns.bucket(), ttlBucket(ns.bucket())} {
+			err := db.DeleteBucket(b)
+			if err != nil {
+				if err.Error() != "bucket not found" {
+					log.WithError(err).Error("Can't clear localStorage")
+				}
+			}
+		}
+		return
+	}
+
+	for _, b := range []string{ns.bucket(), ttlBucket(ns.bucket())} {
+		for _, id := range keys(b, prefix) {
+			db.Delete(b, id)
+		}
+	}
+}
+
+func (ns Namespace) bucket() string {
+	if ns == "" {
+		return bucket
+	}
+
+	return bucket + namespaceSeparator + string(ns)
+}
+
+func keys(bucket, prefix string) []string {
+	var ids []string
+	var err error
+	if prefix == "" {
+		ids, err = db.GetAllKeys(bucket)
+	} else {
+		ids, err = db.GetAllKeysByPrefix(bucket, prefix)
+	}
+	if err != nil {
+		return []string{}
+	}
+
+	return ids
+}
+
+// ttlBucket returns name of the bucket with expiration times of items from bucket provided
+func ttlBucket(bucket string) string {
+	return bucket + ttlSuffix
+}
+
+func expired(bucket, id string, now time.Time) bool {
+	var expiresAt time.Time
+	if err := db.GetUnmarshalledIntoInterface(ttlBucket(bucket), id, &expiresAt); err != nil {
+		return false
+	}
+
+	return !expiresAt.After(now)
+}
+
+// deleteExpired deletes expired items of the bucket from DB
+func deleteExpired(bucket string) {
+	ids, err := db.GetAllKeys(ttlBucket(bucket))
+	if err != nil {
+		return
+	}
+
+	locker.Lock()
+	defer locker.Unlock()
+	now := time.Now()
+	for _, id := range ids {
+		if expired(bucket, id, now) {
+			db.Delete(bucket, id)
+			db.Delete(ttlBucket(bucket), id)
+		}
+	}
+}
+
+// deleteAllExpired deletes expired items of all namespaces from DB
+func deleteAllExpired() {
+	buckets, err := db.GetAllBucketsByPrefix(bucket)
+	if err != nil {
+		log.WithError(err).Error("Can't list localStorage buckets")
+		return
+	}
+	for _, b := range buckets {
+		if !strings.HasSuffix(b, ttlSuffix) {
+			continue
+		}
+		itemsBucket := strings.TrimSuffix(b, ttlSuffix)
+		if itemsBucket == bucket || strings.HasPrefix(itemsBucket, bucket+namespaceSeparator) {
+			deleteExpired(itemsBucket)
+		}
+	}
+}
+
+func sweeper() {
+	c := time.Tick(time.Minute)
+	for {
+		<-c
+		deleteAllExpired()
 	}
 }
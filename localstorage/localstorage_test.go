@@ -0,0 +1,95 @@
+package localstorage
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+)
+
+func TestLocalStorage(t *testing.T) {
+	g := Goblin(t)
+	g.Describe("localStorage", func() {
+		g.Before(func() {
+			clear()
+		})
+
+		g.Describe("#SetItemWithTTL", func() {
+			g.It("should hide expired item", func() {
+				SetItemWithTTL("cache", "value", time.Millisecond*10)
+				g.Assert(GetItem("cache")).Equal("value")
+				time.Sleep(time.Millisecond * 20)
+				g.Assert(GetItem("cache") == nil).IsTrue()
+			})
+			g.It("should remove ttl when item set again without it", func() {
+				SetItemWithTTL("persistent", "value", time.Millisecond*10)
+				SetItem("persistent", "value")
+				time.Sleep(time.Millisecond * 20)
+				g.Assert(GetItem("persistent")).Equal("value")
+			})
+		})
+
+		g.Describe("#deleteExpired", func() {
+			g.It("should delete expired items from DB", func() {
+				SetItemWithTTL("expired", "value", time.Millisecond)
+				SetItemWithTTL("fresh", "value", time.Minute)
+				time.Sleep(time.Millisecond * 5)
+				deleteExpired(bucket)
+				_, err := db.Get(bucket, "expired")
+				g.Assert(err == nil).IsFalse()
+				_, err = db.Get(ttlBucket(bucket), "expired")
+				g.Assert(err == nil).IsFalse()
+				g.Assert(GetItem("fresh")).Equal("value")
+			})
+		})
+
+		g.Describe("Namespace", func() {
+			g.Before(func() {
+				Namespace("billing").Clear("")
+				Namespace("reports").Clear("")
+			})
+
+			g.It("should keep items of namespaces separately", func() {
+				Namespace("billing").SetItemWithTTL("rate", "1", 0)
+				Namespace("reports").SetItemWithTTL("rate", "2", 0)
+				g.Assert(Namespace("billing").GetItem("rate")).Equal("1")
+				g.Assert(Namespace("reports").GetItem("rate")).Equal("2")
+				Namespace("billing").Clear("")
+				g.Assert(Namespace("billing").GetItem("rate") == nil).IsTrue()
+				g.Assert(Namespace("reports").GetItem("rate")).Equal("2")
+			})
+			g.It("should return keys by prefix without expired items", func() {
+				ns := Namespace("reports")
+				ns.SetItemWithTTL("cache.a", "a", 0)
+				ns.SetItemWithTTL("cache.b", "b", time.Millisecond)
+				ns.SetItemWithTTL("other", "c", 0)
+				time.Sleep(time.Millisecond * 5)
+				g.Assert(ns.Keys("cache.")).Equal([]string{"cache.a"})
+				g.Assert(len(ns.Keys(""))).Equal(3)
+			})
+			g.It("should return many items", func() {
+				ns := Namespace("reports")
+				g.Assert(ns.GetMany([]string{"cache.a", "other", "unknown"})).Equal(map[string]interface{}{"cache.a": "a", "other": "c"})
+			})
+			g.It("should clear items by prefix", func() {
+				ns := Namespace("reports")
+				ns.Clear("cache.")
+				g.Assert(ns.GetItem("cache.a") == nil).IsTrue()
+				g.Assert(ns.GetItem("other")).Equal("c")
+			})
+			g.It("should sweep expired items of all namespaces", func() {
+				ns := Namespace("billing")
+				ns.SetItemWithTTL("expired", "value", time.Millisecond)
+				time.Sleep(time.Millisecond * 5)
+				deleteAllExpired()
+				_, err := db.Get(ns.bucket(), "expired")
+				g.Assert(err == nil).IsFalse()
+			})
+			g.It("should not accept names that mix up with other buckets", func() {
+				g.Assert(Namespace("billing").Valid()).IsTrue()
+				g.Assert(Namespace("billing:2019").Valid()).IsFalse()
+				g.Assert(Namespace("billing__ttl").Valid()).IsFalse()
+			})
+		})
+	})
+}
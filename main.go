@@ -21,15 +21,24 @@ import (
 	"golang.org/x/tools/godoc/vfs"
 	"golang.org/x/tools/godoc/vfs/zipfs"
 
+	"github.com/getblank/blank-sr/apikeys"
 	"github.com/getblank/blank-sr/config"
+	"github.com/getblank/blank-sr/election"
+	"github.com/getblank/blank-sr/localstorage"
+	"github.com/getblank/blank-sr/ratelimit"
 	"github.com/getblank/blank-sr/registry"
 	"github.com/getblank/blank-sr/sessionstore"
 	blankSync "github.com/getblank/blank-sr/sync"
+	"github.com/getblank/blank-sr/tokenstore"
 )
 
 const (
 	libZipFileName    = "lib.zip"
 	assetsZipFileName = "assets.zip"
+
+	electionTopicPrefix = "election."
+	introspectScope     = "introspect"
+	syncAdminScope      = "sync.admin"
 )
 
 var (
@@ -63,6 +72,14 @@ func main() {
 		return
 	}
 
+	if flag.Arg(0) == "sessions" {
+		if err := sessionsCommand(flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	start()
 }
 
@@ -74,6 +91,10 @@ func start() {
 	config.RegisterMongoCFGProvider()
 	config.Init("./config.json")
 	sessionstore.Init()
+	tokenstore.Init()
+	ratelimit.Init()
+	localstorage.Init()
+	election.Init()
 
 	wamp.SetSessionOpenCallback(onSessionOpen)
 	wamp.SetSessionCloseCallback(onSessionClose)
@@ -92,32 +113,82 @@ func start() {
 	mux.HandleFunc("/lib/", libHandler)
 	mux.HandleFunc("/assets/", assetsHandler)
 	mux.HandleFunc("/public-key", publicKeyHandler)
+	mux.HandleFunc("/sso/exchange", ssoExchangeHTTPHandler)
+	mux.HandleFunc("/oauth/introspect", introspectHandler)
+	mux.HandleFunc("/.well-known/openid-configuration", openIDConfigurationHandler)
+	mux.HandleFunc("/.well-known/jwks.json", jwksHandler)
+	mux.HandleFunc("/sync/locks", syncLocksHandler)
 
 	wamp.RegisterSubHandler("registry", registryHandler, nil, nil)
 	wamp.RegisterSubHandler("config", configHandler, nil, nil)
 	wamp.RegisterSubHandler("sessions", subSessionsHandler, nil, nil)
 	wamp.RegisterSubHandler("events", nil, nil, nil)
 	wamp.RegisterSubHandler("users", nil, nil, nil)
+	wamp.RegisterSubHandler("sync", nil, nil, nil)
+	wamp.RegisterSubHandler(electionTopicPrefix, subElectionHandler, nil, nil)
 
 	wamp.RegisterRPCHandler("register", registerHandler)
 	wamp.RegisterRPCHandler("publish", publishHandler)
 
 	wamp.RegisterRPCHandler("session.new", newSessionHandler)
+	wamp.RegisterRPCHandler("session.new-pending", newPendingSessionHandler)
+	wamp.RegisterRPCHandler("session.elevate", elevateSessionHandler)
+	wamp.RegisterRPCHandler("session.impersonate", impersonateSessionHandler)
+	wamp.RegisterRPCHandler("session.end-impersonation", endImpersonationHandler)
+	wamp.RegisterRPCHandler("session.sso-code", ssoCodeHandler)
+	wamp.RegisterRPCHandler("session.sso-exchange", ssoExchangeHandler)
 	wamp.RegisterRPCHandler("session.check", checkSessionByAPIKeyHandler)
 	wamp.RegisterRPCHandler("session.delete", deleteSessionHandler)
 	wamp.RegisterRPCHandler("session.subscribed", sessionSubscribedHandler)
 	wamp.RegisterRPCHandler("session.unsubscribed", sessionUnsubscribedHandler)
 	wamp.RegisterRPCHandler("session.delete-connection", sessionDeleteConnectionHandler)
+	wamp.RegisterRPCHandler("session.batch", sessionBatchHandler)
+	wamp.RegisterRPCHandler("session.sync-connections", sessionSyncConnectionsHandler)
 	wamp.RegisterRPCHandler("session.user-update", sessionUserUpdateHandler)
+	wamp.RegisterRPCHandler("session.export", sessionExportHandler)
+	wamp.RegisterRPCHandler("session.import", sessionImportHandler)
+
+	wamp.RegisterRPCHandler("token.issue", tokenIssueHandler)
+	wamp.RegisterRPCHandler("token.consume", tokenConsumeHandler)
+
+	wamp.RegisterRPCHandler("apikey.create", apiKeyCreateHandler)
+	wamp.RegisterRPCHandler("apikey.list", apiKeyListHandler)
+	wamp.RegisterRPCHandler("apikey.revoke", apiKeyRevokeHandler)
+	wamp.RegisterRPCHandler("apikey.verify", apiKeyVerifyHandler)
 
 	wamp.RegisterRPCHandler("sync.lock", syncLockHandler)
+	wamp.RegisterRPCHandler("sync.tryLock", syncTryLockHandler)
 	wamp.RegisterRPCHandler("sync.unlock", syncUnlockHandler)
+	wamp.RegisterRPCHandler("sync.rlock", syncRLockHandler)
+	wamp.RegisterRPCHandler("sync.runlock", syncRUnlockHandler)
+	wamp.RegisterRPCHandler("sync.acquire", syncAcquireHandler)
+	wamp.RegisterRPCHandler("sync.release", syncReleaseHandler)
+	wamp.RegisterRPCHandler("sync.renew", syncRenewHandler)
+	wamp.RegisterRPCHandler("sync.validate", syncValidateHandler)
+	wamp.RegisterRPCHandler("sync.inspect", syncInspectHandler)
+	wamp.RegisterRPCHandler("sync.forceRelease", syncForceReleaseHandler)
 	wamp.RegisterRPCHandler("sync.once", syncOnceHandler)
+	wamp.RegisterRPCHandler("sync.barrier", syncBarrierHandler)
+	wamp.RegisterRPCHandler("latch.create", latchCreateHandler)
+	wamp.RegisterRPCHandler("latch.countDown", latchCountDownHandler)
+	wamp.RegisterRPCHandler("latch.await", latchAwaitHandler)
+	wamp.RegisterRPCHandler("ratelimit.take", rateLimitTakeHandler)
+	wamp.RegisterRPCHandler("counter.inc", counterIncHandler)
+	wamp.RegisterRPCHandler("counter.get", counterGetHandler)
+	wamp.RegisterRPCHandler("counter.set", counterSetHandler)
+	wamp.RegisterRPCHandler("sequence.next", sequenceNextHandler)
+	wamp.RegisterRPCHandler("election.campaign", electionCampaignHandler)
+	wamp.RegisterRPCHandler("election.resign", electionResignHandler)
+	wamp.RegisterRPCHandler("election.leader", electionLeaderHandler)
+	wamp.RegisterRPCHandler("sync.do", syncDoHandler)
+	wamp.RegisterRPCHandler("sync.complete", syncCompleteHandler)
 
 	wamp.RegisterRPCHandler("localStorage.getItem", localStorageGetItemHandler)
 	wamp.RegisterRPCHandler("localStorage.setItem", localStorageSetItemHandler)
 	wamp.RegisterRPCHandler("localStorage.removeItem", localStorageRemoveItemHandler)
 	wamp.RegisterRPCHandler("localStorage.clear", localStorageClearHandler)
+	wamp.RegisterRPCHandler("localStorage.keys", localStorageKeysHandler)
+	wamp.RegisterRPCHandler("localStorage.getMany", localStorageGetManyHandler)
 
 	registry.OnCreate(func(_ registry.Service) {
 		services := registry.GetAll()
@@ -130,8 +201,8 @@ func start() {
 	})
 
 	registry.OnDelete(func(s registry.Service) {
-		if s.Type == "taskQueue" { // router restarted?
-			sessionstore.DeleteAllConnections()
+		if s.Type == registry.TypeTaskQueue { // router restarted?
+			sessionstore.DeleteConnectionsForOwner(s.ConnID())
 		}
 		services := registry.GetAll()
 		wamp.Publish("registry", services)
@@ -145,6 +216,18 @@ func start() {
 		wamp.Publish("sessions", map[string]interface{}{"event": "deleted", "data": s})
 	})
 
+	blankSync.OnLeaseExpired(func(owner, id string) {
+		event := map[string]interface{}{"event": "leaseExpired", "id": id, "owner": owner}
+		if s, ok := registry.GetByConnID(owner); ok {
+			event["service"] = s
+		}
+		wamp.Publish("sync", event)
+	})
+
+	election.OnChange(func(name string, term int, leader *election.Leader) {
+		wamp.Publish(electionTopicPrefix+name, leaderEvent(term, leader))
+	})
+
 	config.OnUpdate(func(c map[string]config.Store) {
 		log.Info("Config updated. Will publish to receivers")
 		wamp.Publish("config", c)
@@ -167,6 +250,7 @@ func onSessionClose(c *wango.Conn) {
 	println("Disconnected client from SR", c.ID())
 	registry.Unregister(c.ID())
 	blankSync.UnlockForOwner(c.ID())
+	election.ResignAll(c.ID())
 }
 
 func onSessionOpen(c *wango.Conn) {
@@ -326,6 +410,164 @@ func publicKeyHandler(rw http.ResponseWriter, request *http.Request) {
 	rw.Write(sessionstore.PublicKeyBytes())
 }
 
+// ssoExchangeHTTPHandler exchanges SSO code for the new session.
+// Request body must be JSON {"code": "...", "origin": "..."}.
+// Origin header, if present, must match origin from body.
+func ssoExchangeHTTPHandler(rw http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusBadRequest)
+		rw.Write([]byte("Only POST request is allowed"))
+		return
+	}
+
+	var data struct {
+		Code   string `json:"code"`
+		Origin string `json:"origin"`
+	}
+	err := json.NewDecoder(request.Body).Decode(&data)
+	if err != nil || data.Code == "" || data.Origin == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		rw.Write([]byte("code and origin required"))
+		return
+	}
+
+	if origin := request.Header.Get("Origin"); origin != "" && origin != data.Origin {
+		rw.WriteHeader(http.StatusForbidden)
+		rw.Write([]byte(sessionstore.ErrOriginNotAllowed.Error()))
+		return
+	}
+
+	s, err := sessionstore.ExchangeSSOCode(data.Code, data.Origin, request.RemoteAddr)
+	if err != nil {
+		rw.WriteHeader(http.StatusForbidden)
+		rw.Write([]byte(err.Error()))
+		return
+	}
+
+	writeJSON(rw, map[string]string{"access_token": s.AccessToken})
+}
+
+// introspectHandler implements RFC 7662 token introspection endpoint.
+// Token must be passed as "token" form parameter.
+// Caller must pass API key with introspect scope as bearer token in Authorization header.
+func introspectHandler(rw http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusBadRequest)
+		rw.Write([]byte("Only POST request is allowed"))
+		return
+	}
+
+	if _, ok := authorize(rw, request, introspectScope); !ok {
+		return
+	}
+
+	token := request.PostFormValue("token")
+	if token == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		rw.Write([]byte("token required"))
+		return
+	}
+
+	writeJSON(rw, sessionstore.Introspect(token))
+}
+
+// openIDConfigurationHandler returns OpenID Connect discovery document.
+// Returns 404 if issuerUrl server setting is not configured.
+func openIDConfigurationHandler(rw http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		rw.WriteHeader(http.StatusBadRequest)
+		rw.Write([]byte("Only GET request is allowed"))
+		return
+	}
+
+	doc, err := sessionstore.Discovery()
+	if err != nil {
+		rw.WriteHeader(http.StatusNotFound)
+		rw.Write([]byte(err.Error()))
+		return
+	}
+
+	writeJSON(rw, doc)
+}
+
+func jwksHandler(rw http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		rw.WriteHeader(http.StatusBadRequest)
+		rw.Write([]byte("Only GET request is allowed"))
+		return
+	}
+
+	writeJSON(rw, sessionstore.JWKS())
+}
+
+// syncLocksHandler lists locks on GET and force releases lock provided in "id" query parameter on DELETE.
+// Optional "reason" query parameter is written to the audit log.
+// DELETE requires API key with sync.admin scope as bearer token, key ID is written to the audit log as "by".
+func syncLocksHandler(rw http.ResponseWriter, request *http.Request) {
+	switch request.Method {
+	case http.MethodGet:
+		writeJSON(rw, inspectLocks())
+	case http.MethodDelete:
+		key, ok := authorize(rw, request, syncAdminScope)
+		if !ok {
+			return
+		}
+
+		id := request.URL.Query().Get("id")
+		if id == "" {
+			rw.WriteHeader(http.StatusBadRequest)
+			rw.Write([]byte("id required"))
+			return
+		}
+
+		if err := blankSync.ForceRelease(id, key.ID, request.URL.Query().Get("reason")); err != nil {
+			rw.WriteHeader(http.StatusNotFound)
+			rw.Write([]byte(err.Error()))
+			return
+		}
+
+		rw.Write([]byte("OK"))
+	default:
+		rw.WriteHeader(http.StatusBadRequest)
+		rw.Write([]byte("Only GET and DELETE requests are allowed"))
+	}
+}
+
+// inspectLocks returns state of all locks with registry identities of their owners
+func inspectLocks() map[string]interface{} {
+	locks := blankSync.Inspect()
+	owners := map[string]registry.Service{}
+	for _, l := range locks {
+		for _, owner := range l.Owners() {
+			if s, ok := registry.GetByConnID(owner); ok {
+				owners[owner] = s
+			}
+		}
+	}
+
+	return map[string]interface{}{"locks": locks, "owners": owners}
+}
+
+// authorize verifies API key with scope passed as bearer token in Authorization header.
+// Writes 401 response and returns false if key is not valid.
+func authorize(rw http.ResponseWriter, request *http.Request, scope string) (*apikeys.Key, bool) {
+	key, err := apikeys.Verify(strings.TrimPrefix(request.Header.Get("Authorization"), "Bearer "), scope)
+	if err != nil {
+		rw.Header().Set("WWW-Authenticate", "Bearer")
+		rw.WriteHeader(http.StatusUnauthorized)
+		rw.Write([]byte(err.Error()))
+		return nil, false
+	}
+
+	return key, true
+}
+
+func writeJSON(rw http.ResponseWriter, data interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(data)
+}
+
 func assetsHandler(rw http.ResponseWriter, request *http.Request) {
 	switch request.Method {
 	case http.MethodPost:
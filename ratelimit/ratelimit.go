@@ -0,0 +1,240 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/getblank/blank-sr/bdb"
+	"github.com/getblank/blank-sr/berror"
+	"github.com/getblank/blank-sr/config"
+)
+
+var (
+	states = map[string]*state{}
+	locker sync.Mutex
+
+	bucket = "__rateLimits"
+	db     = bdb.DB{}
+
+	limitByName = config.RateLimitByName
+
+	// ErrUnknownLimit returns when limit is not defined in server settings
+	ErrUnknownLimit = errors.New("unknown rate limit")
+	// ErrInvalidLimit returns when limit definition is invalid
+	ErrInvalidLimit = errors.New("invalid rate limit")
+	// ErrInvalidCost returns when cost is negative or greater than the limit
+	ErrInvalidCost = errors.New("invalid rate limit cost")
+)
+
+// Result is the result of Take
+type Result struct {
+	Allowed    bool    `json:"allowed"`
+	Remaining  float64 `json:"remaining"`
+	RetryAfter float64 `json:"retryAfter"` // seconds to wait before the same cost can be taken
+}
+
+type state struct {
+	Key       string    `json:"key"`
+	Tokens    float64   `json:"tokens,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Events    []event   `json:"events,omitempty"`
+}
+
+type event struct {
+	At   time.Time `json:"at"`
+	Cost float64   `json:"cost"`
+}
+
+// Init is the entrypoint of ratelimit
+func Init() {
+	go gcWatcher()
+}
+
+// Take takes cost from the budget of the key. Key is "<limit>" or "<limit>:<subject>",
+// where limit is the name of the definition in rateLimits section of server settings.
+// Every subject of the limit has its own budget. Zero cost returns remaining quota without taking it.
+func Take(key string, cost float64) (*Result, error) {
+	def, err := definition(key)
+	if err != nil {
+		return nil, err
+	}
+	if cost < 0 || cost > def.Limit {
+		return nil, ErrInvalidCost
+	}
+
+	locker.Lock()
+	defer locker.Unlock()
+	now := time.Now()
+	s := getState(key, def, now)
+	var res *Result
+	if def.Mode == config.RateLimitSlidingWindow {
+		res = s.takeWindow(def, cost, now)
+	} else {
+		res = s.takeToken(def, cost, now)
+	}
+
+	if def.Persist {
+		if err := db.Save(bucket, key, s); err != nil {
+			log.WithError(err).Errorf("Can't save rate limit state for key %s", key)
+		}
+	}
+
+	return res, nil
+}
+
+func definition(key string) (config.RateLimit, error) {
+	name := key
+	if i := strings.Index(key, ":"); i >= 0 {
+		name = key[:i]
+	}
+	def, ok := limitByName(name)
+	if !ok {
+		return def, ErrUnknownLimit
+	}
+	if def.Limit <= 0 || def.Period <= 0 {
+		return def, ErrInvalidLimit
+	}
+	if def.Mode != "" && def.Mode != config.RateLimitTokenBucket && def.Mode != config.RateLimitSlidingWindow {
+		return def, ErrInvalidLimit
+	}
+
+	return def, nil
+}
+
+// getState returns state of the key. If limit is persisted and state is not in memory, it is loaded from DB.
+// locker must be locked.
+func getState(key string, def config.RateLimit, now time.Time) *state {
+	if s, ok := states[key]; ok {
+		return s
+	}
+
+	s := new(state)
+	if !def.Persist || db.GetUnmarshalledIntoInterface(bucket, key, s) != nil {
+		s = &state{Key: key, Tokens: def.Limit, UpdatedAt: now}
+	}
+	states[key] = s
+
+	return s
+}
+
+func (s *state) takeToken(def config.RateLimit, cost float64, now time.Time) *Result {
+	rate := def.Limit / def.PeriodDuration().Seconds()
+	s.refill(def, now)
+	if s.Tokens >= cost {
+		s.Tokens -= cost
+		return &Result{Allowed: true, Remaining: s.Tokens}
+	}
+
+	return &Result{Remaining: s.Tokens, RetryAfter: (cost - s.Tokens) / rate}
+}
+
+func (s *state) refill(def config.RateLimit, now time.Time) {
+	rate := def.Limit / def.PeriodDuration().Seconds()
+	s.Tokens += now.Sub(s.UpdatedAt).Seconds() * rate
+	if s.Tokens > def.Limit {
+		s.Tokens = def.Limit
+	}
+	s.UpdatedAt = now
+}
+
+func (s *state) takeWindow(def config.RateLimit, cost float64, now time.Time) *Result {
+	period := def.PeriodDuration()
+	used := s.slide(period, now)
+	if used+cost <= def.Limit {
+		if cost > 0 {
+			s.Events = append(s.Events, event{At: now, Cost: cost})
+		}
+		return &Result{Allowed: true, Remaining: def.Limit - used - cost}
+	}
+
+	res := &Result{Remaining: def.Limit - used}
+	need := used + cost - def.Limit
+	var freed float64
+	for _, e := range s.Events {
+		freed += e.Cost
+		if freed >= need {
+			res.RetryAfter = e.At.Add(period).Sub(now).Seconds()
+			break
+		}
+	}
+
+	return res
+}
+
+// slide removes events out of the window and returns cost used in the window
+func (s *state) slide(period time.Duration, now time.Time) float64 {
+	start := now.Add(-period)
+	i := 0
+	for i < len(s.Events) && !s.Events[i].At.After(start) {
+		i++
+	}
+	s.Events = s.Events[i:]
+	s.UpdatedAt = now
+
+	var used float64
+	for _, e := range s.Events {
+		used += e.Cost
+	}
+
+	return used
+}
+
+// idle returns true if the budget of the state is fully restored, so the state can be forgotten
+func (s *state) idle(def config.RateLimit, now time.Time) bool {
+	if def.Mode == config.RateLimitSlidingWindow {
+		return s.slide(def.PeriodDuration(), now) == 0
+	}
+	s.refill(def, now)
+
+	return s.Tokens >= def.Limit
+}
+
+// clearIdleStates removes states of the keys with fully restored budget from memory and DB
+func clearIdleStates() {
+	locker.Lock()
+	defer locker.Unlock()
+	now := time.Now()
+	for key, s := range states {
+		def, err := definition(key)
+		if err != nil || s.idle(def, now) {
+			delete(states, key)
+		}
+	}
+
+	persisted, err := db.GetAll(bucket)
+	if err != nil {
+		if err != berror.DbNotFound {
+			log.Error("Can't read all rate limit states", err.Error())
+		}
+		return
+	}
+	for _, encoded := range persisted {
+		s := new(state)
+		if err := json.Unmarshal(encoded, s); err != nil {
+			log.Error("Can't unmarshal rate limit state", string(encoded), err.Error())
+			continue
+		}
+		if _, ok := states[s.Key]; ok {
+			continue
+		}
+		def, err := definition(s.Key)
+		if err != nil || !def.Persist || s.idle(def, now) {
+			if err := db.Delete(bucket, s.Key); err != nil {
+				log.Error("Can't delete rate limit state", err.Error())
+			}
+		}
+	}
+}
+
+func gcWatcher() {
+	c := time.Tick(time.Minute)
+	for {
+		<-c
+		clearIdleStates()
+	}
+}
@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+
+	"github.com/getblank/blank-sr/config"
+)
+
+func TestRateLimit(t *testing.T) {
+	g := Goblin(t)
+	g.Describe("Rate limit", func() {
+		g.Before(func() {
+			db.DeleteBucket(bucket)
+			limits := map[string]config.RateLimit{
+				"bucket":    {Limit: 2, Period: 1},
+				"window":    {Mode: config.RateLimitSlidingWindow, Limit: 2, Period: 0.1},
+				"persisted": {Limit: 2, Period: 60, Persist: true},
+			}
+			limitByName = func(name string) (config.RateLimit, bool) {
+				l, ok := limits[name]
+				return l, ok
+			}
+		})
+
+		g.Describe("#Take", func() {
+			g.It("should reject unknown limit and invalid cost", func() {
+				_, err := Take("unknown", 1)
+				g.Assert(err).Equal(ErrUnknownLimit)
+				_, err = Take("bucket", 3)
+				g.Assert(err).Equal(ErrInvalidCost)
+			})
+			g.It("should take tokens from the bucket until it is empty", func() {
+				res, _ := Take("bucket:api", 1)
+				g.Assert(res.Allowed).IsTrue()
+				res, _ = Take("bucket:api", 1)
+				g.Assert(res.Allowed).IsTrue()
+				res, _ = Take("bucket:api", 1)
+				g.Assert(res.Allowed).IsFalse()
+				g.Assert(res.RetryAfter > 0 && res.RetryAfter <= 0.5).IsTrue()
+				res, _ = Take("bucket:other", 1)
+				g.Assert(res.Allowed).IsTrue()
+			})
+			g.It("should allow limit cost during sliding window", func() {
+				res, _ := Take("window", 2)
+				g.Assert(res.Allowed).IsTrue()
+				g.Assert(res.Remaining).Equal(float64(0))
+				res, _ = Take("window", 1)
+				g.Assert(res.Allowed).IsFalse()
+				g.Assert(res.RetryAfter > 0 && res.RetryAfter <= 0.1).IsTrue()
+				time.Sleep(time.Millisecond * 110)
+				res, _ = Take("window", 1)
+				g.Assert(res.Allowed).IsTrue()
+				g.Assert(res.Remaining).Equal(float64(1))
+			})
+			g.It("should restore persisted state", func() {
+				Take("persisted", 2)
+				locker.Lock()
+				delete(states, "persisted")
+				locker.Unlock()
+				res, _ := Take("persisted", 1)
+				g.Assert(res.Allowed).IsFalse()
+			})
+		})
+
+		g.Describe("#clearIdleStates", func() {
+			g.It("should forget states with restored budget", func() {
+				Take("window:idle", 1)
+				time.Sleep(time.Millisecond * 110)
+				clearIdleStates()
+				g.Assert(states["window:idle"] == nil).IsTrue()
+				g.Assert(states["persisted"] == nil).IsFalse()
+			})
+		})
+	})
+}
@@ -37,6 +37,11 @@ type Service struct {
 	connID   string
 }
 
+// ConnID returns ID of the WAMP connection the service registered with
+func (s Service) ConnID() string {
+	return s.connID
+}
+
 type RegisterMessage struct {
 	Type string `json:"type"`
 }
@@ -71,6 +76,21 @@ func GetAll() map[string][]Service {
 	return all
 }
 
+// GetByConnID returns service registered with WAMP connection provided
+func GetByConnID(connID string) (Service, bool) {
+	locker.RLock()
+	defer locker.RUnlock()
+	for _, ss := range services {
+		for _, s := range ss {
+			if s.connID == connID {
+				return s, true
+			}
+		}
+	}
+
+	return Service{}, false
+}
+
 // OnCreate pass handler func, that will call when new service will created
 func OnCreate(fn func(Service)) {
 	createHandlers = append(createHandlers, fn)
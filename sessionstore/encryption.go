@@ -0,0 +1,177 @@
+package sessionstore
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Sessions encryption keys are read from BLANK_SESSIONS_KEY env variable or from the file
+// which path is in BLANK_SESSIONS_KEY_FILE env variable.
+// Keys are separated by newlines or commas, every key is in "version:base64Key" format,
+// where key is 16, 24 or 32 bytes long. Key with the highest version encrypts new records,
+// others are used to decrypt records encrypted before rotation.
+const (
+	encryptionKeyEnv     = "BLANK_SESSIONS_KEY"
+	encryptionKeyFileEnv = "BLANK_SESSIONS_KEY_FILE"
+)
+
+var (
+	encryptedPrefix = []byte("enc:")
+
+	ciphers        = map[int]cipher.AEAD{}
+	currentVersion int
+	cipherLocker   sync.RWMutex
+
+	errInvalidEncryptionKey = errors.New("invalid sessions encryption key")
+	errUnknownKeyVersion    = errors.New("unknown sessions encryption key version")
+	errInvalidEncrypted     = errors.New("invalid encrypted session record")
+)
+
+// LoadEncryptionKeys loads sessions encryption keys from env. If no keys provided, sessions are stored unencrypted.
+func LoadEncryptionKeys() error {
+	keys := os.Getenv(encryptionKeyEnv)
+	if path := os.Getenv(encryptionKeyFileEnv); path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		keys = string(b)
+	}
+
+	return setEncryptionKeys(keys)
+}
+
+func setEncryptionKeys(keys string) error {
+	_ciphers := map[int]cipher.AEAD{}
+	var current int
+	for _, entry := range strings.FieldsFunc(keys, func(r rune) bool { return r == '\n' || r == ',' }) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 {
+			return errInvalidEncryptionKey
+		}
+
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || version <= 0 {
+			return errInvalidEncryptionKey
+		}
+
+		key, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return errInvalidEncryptionKey
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return errInvalidEncryptionKey
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return err
+		}
+
+		_ciphers[version] = aead
+		if version > current {
+			current = version
+		}
+	}
+
+	cipherLocker.Lock()
+	defer cipherLocker.Unlock()
+
+	ciphers = _ciphers
+	currentVersion = current
+
+	return nil
+}
+
+// encrypt encrypts session record stored by apiKey with the current key.
+// Encrypted record is "enc:<version>:" followed by nonce and ciphertext.
+// Record is returned as is if no keys loaded.
+func encrypt(apiKey string, data []byte) ([]byte, error) {
+	cipherLocker.RLock()
+	defer cipherLocker.RUnlock()
+
+	if currentVersion == 0 {
+		return data, nil
+	}
+
+	aead := ciphers[currentVersion]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	res := append([]byte{}, encryptedPrefix...)
+	res = strconv.AppendInt(res, int64(currentVersion), 10)
+	res = append(res, ':')
+	res = append(res, nonce...)
+
+	return aead.Seal(res, nonce, data, additionalData(currentVersion, apiKey)), nil
+}
+
+// decrypt decrypts session record stored by apiKey. Unencrypted records are returned as is.
+func decrypt(apiKey string, data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, encryptedPrefix) {
+		return data, nil
+	}
+
+	data = data[len(encryptedPrefix):]
+	i := bytes.IndexByte(data, ':')
+	if i < 0 {
+		return nil, errInvalidEncrypted
+	}
+
+	version, err := strconv.Atoi(string(data[:i]))
+	if err != nil {
+		return nil, errInvalidEncrypted
+	}
+	data = data[i+1:]
+
+	cipherLocker.RLock()
+	aead, ok := ciphers[version]
+	cipherLocker.RUnlock()
+	if !ok {
+		return nil, errUnknownKeyVersion
+	}
+
+	if len(data) < aead.NonceSize() {
+		return nil, errInvalidEncrypted
+	}
+
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+
+	return aead.Open(nil, nonce, ciphertext, additionalData(version, apiKey))
+}
+
+// additionalData binds encrypted record to the key version and to the key it is stored by,
+// so record copied to another session key can't be decrypted
+func additionalData(version int, apiKey string) []byte {
+	return []byte(strconv.Itoa(version) + ":" + apiKey)
+}
+
+// needsReencryption returns true if record is not encrypted with the current key
+func needsReencryption(data []byte) bool {
+	cipherLocker.RLock()
+	defer cipherLocker.RUnlock()
+
+	if currentVersion == 0 {
+		return false
+	}
+
+	return !bytes.HasPrefix(data, []byte(string(encryptedPrefix)+strconv.Itoa(currentVersion)+":"))
+}
@@ -0,0 +1,173 @@
+package sessionstore
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"time"
+
+	"github.com/getblank/blank-sr/berror"
+	"github.com/golang-jwt/jwt"
+	log "github.com/sirupsen/logrus"
+)
+
+// ExportFilter limits sessions written by Export
+type ExportFilter struct {
+	UserID interface{}   // export only sessions of the user if not nil
+	MaxAge time.Duration // export only sessions created not earlier than MaxAge ago if not 0
+}
+
+// errInvalidRecord returns when imported session record fails validation
+var errInvalidRecord = errors.New("invalid session record")
+
+// Export writes stored sessions matching filter to w as JSON lines. Expired sessions are not exported.
+// Returns number of exported sessions.
+func Export(w io.Writer, filter ExportFilter) (int, error) {
+	apiKeys, err := db.GetAllKeys(bucket)
+	if err != nil && err != berror.DbNotFound {
+		return 0, err
+	}
+
+	now := time.Now()
+	enc := json.NewEncoder(w)
+	var count int
+	for _, apiKey := range apiKeys {
+		encrypted, err := db.Get(bucket, apiKey)
+		if err != nil {
+			log.Error("Can't read session", apiKey, err.Error())
+			continue
+		}
+
+		encoded, err := decrypt(apiKey, encrypted)
+		if err != nil {
+			log.Error("Can't decrypt session", apiKey, err.Error())
+			continue
+		}
+
+		var s Session
+		if err := json.Unmarshal(encoded, &s); err != nil {
+			log.Error("Can't unmarshal session", string(encoded), err.Error())
+			continue
+		}
+
+		if s.TTL.Before(now) {
+			continue
+		}
+
+		if filter.UserID != nil && s.UserID != filter.UserID {
+			continue
+		}
+
+		if filter.MaxAge > 0 && s.CreatedAt.Before(now.Add(-filter.MaxAge)) {
+			continue
+		}
+
+		s.Connections = []*Conn{}
+		if err := enc.Encode(&s); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// Import reads sessions from r as JSON lines and adds them to store.
+// Records with invalid token, expired records and records for existing sessions are skipped.
+// Tokens are verified with the current public key, so keys must be loaded before.
+func Import(r io.Reader) (imported, skipped int, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	now := time.Now()
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		s := new(Session)
+		if err := json.Unmarshal(line, s); err != nil {
+			log.Warn("Can't unmarshal imported session", err.Error())
+			skipped++
+			continue
+		}
+
+		if s.TTL.Before(now) {
+			skipped++
+			continue
+		}
+
+		if err := validateImported(s); err != nil {
+			log.Warnf("Imported session %q is invalid: %v", s.APIKey, err)
+			skipped++
+			continue
+		}
+
+		if !addImported(s) {
+			skipped++
+			continue
+		}
+		imported++
+	}
+
+	return imported, skipped, scanner.Err()
+}
+
+func validateImported(s *Session) error {
+	if s.APIKey == "" || s.UserID == nil {
+		return errInvalidRecord
+	}
+
+	claims := jwt.MapClaims{}
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	_, err := parser.ParseWithClaims(s.AccessToken, claims, func(*jwt.Token) (interface{}, error) {
+		return PublicKey(), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if claims["sessionId"] != s.APIKey || !reflect.DeepEqual(claims["userId"], s.UserID) {
+		return errInvalidRecord
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok || int64(exp) != s.TTL.Unix() {
+		return errInvalidRecord
+	}
+
+	if pending, _ := claims[mfaPendingClaim].(bool); pending != s.Pending {
+		return errInvalidRecord
+	}
+
+	act, hasAct := claims["act"].(map[string]interface{})
+	if hasAct != (s.Actor != nil) {
+		return errInvalidRecord
+	}
+	if hasAct && (act["sessionId"] != s.Actor.SessionID || !reflect.DeepEqual(act["sub"], s.Actor.UserID)) {
+		return errInvalidRecord
+	}
+
+	return nil
+}
+
+func addImported(s *Session) bool {
+	locker.Lock()
+	defer locker.Unlock()
+
+	if _, ok := sessions[s.APIKey]; ok {
+		return false
+	}
+
+	if _, err := db.Get(bucket, s.APIKey); err == nil {
+		return false
+	}
+
+	s.Connections = []*Conn{}
+	sessions[s.APIKey] = s
+	sessionUpdated(s)
+
+	return true
+}
@@ -0,0 +1,83 @@
+package sessionstore
+
+import (
+	"errors"
+	"time"
+
+	"github.com/getblank/blank-sr/config"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrNestedImpersonation returns when impersonation requested from impersonated session
+	ErrNestedImpersonation = errors.New("impersonation from impersonated session is not allowed")
+	// ErrNotImpersonated returns when session was not created by impersonation
+	ErrNotImpersonated = errors.New("session is not impersonated")
+)
+
+// Actor represents the user acting on behalf of the session user.
+// It is placed into JWT as "act" claim.
+type Actor struct {
+	UserID    interface{} `json:"userId"`
+	SessionID string      `json:"sessionId"`
+}
+
+// Impersonate creates new session for user on behalf of the user of actor session.
+// Lifetime of the session is taken from impersonationTtl server setting, but it never outlives the actor session.
+// Impersonate doesn't know user roles, caller must check that the actor is allowed to impersonate.
+func Impersonate(actorAPIKey string, user map[string]interface{}) (*Session, error) {
+	actor, err := getByAPIKey(actorAPIKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if actor.IsPending() {
+		return nil, ErrSessionPending
+	}
+
+	if actor.IsImpersonated() {
+		return nil, ErrNestedImpersonation
+	}
+
+	lifetime, err := config.ImpersonationTTL()
+	if err != nil {
+		log.WithError(err).Error("Can't get impersonation TTL. Will setup 1 hour")
+		lifetime = time.Hour
+	}
+
+	actor.RLock()
+	actorTTL := actor.TTL
+	actor.RUnlock()
+	if actorTTL.Before(time.Now()) {
+		return nil, ErrSessionExpired
+	}
+
+	log.Infof("User %v impersonates user %v", actor.GetUserID(), user["_id"])
+	return newSession(user, "", sessionOptions{
+		lifetime: lifetime,
+		notAfter: actorTTL,
+		actor:    &Actor{UserID: actor.GetUserID(), SessionID: actor.GetAPIKey()},
+	}), nil
+}
+
+// EndImpersonation deletes impersonated session and returns session of the actor.
+func EndImpersonation(apiKey string) (*Session, error) {
+	s, err := getByAPIKey(apiKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if !s.IsImpersonated() {
+		return nil, ErrNotImpersonated
+	}
+
+	s.Delete()
+	log.Infof("User %v ended impersonation of user %v", s.Actor.UserID, s.GetUserID())
+
+	return getByAPIKey(s.Actor.SessionID)
+}
+
+// IsImpersonated returns true if session was created by impersonation
+func (s *Session) IsImpersonated() bool {
+	return s.Actor != nil
+}
@@ -0,0 +1,93 @@
+package sessionstore
+
+import (
+	"errors"
+	"time"
+
+	"github.com/getblank/blank-sr/config"
+	"github.com/golang-jwt/jwt"
+	log "github.com/sirupsen/logrus"
+)
+
+const mfaPendingClaim = "mfa_pending"
+
+var (
+	// ErrSessionPending returns when session waits for the second authentication factor
+	ErrSessionPending = errors.New("session is pending second factor authentication")
+	// ErrNotPending returns when elevating session that is not pending
+	ErrNotPending = errors.New("session is not pending")
+	// ErrSessionExpired returns when elevating or impersonating from session that is already expired
+	ErrSessionExpired = errors.New("session is expired")
+)
+
+// NewPending creates new user session waiting for the second authentication factor.
+// Token of the session carries "mfa_pending" claim and lives for mfaPendingTtl from server settings.
+func NewPending(user map[string]interface{}, sessionID string) *Session {
+	lifetime, err := config.MFAPendingTTL()
+	if err != nil {
+		log.WithError(err).Error("Can't get MFA pending TTL. Will setup 5 minutes")
+		lifetime = time.Minute * 5
+	}
+
+	return newSession(user, sessionID, sessionOptions{lifetime: lifetime, pending: true})
+}
+
+// Elevate upgrades pending session to the full one after the second factor succeeded.
+// Token is re-signed with JWT TTL and auth methods used placed into "amr" claim.
+func Elevate(apiKey string, methods []string) (*Session, error) {
+	s, err := getByAPIKey(apiKey)
+	if err != nil {
+		return nil, err
+	}
+
+	jwtTTL, err := config.JWTTTL()
+	if err != nil {
+		log.WithError(err).Error("Can't get JWT TTL. Will setup 24 hours")
+		jwtTTL = time.Hour * 24
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.Pending {
+		return nil, ErrNotPending
+	}
+	if s.TTL.Before(time.Now()) {
+		return nil, ErrSessionExpired
+	}
+
+	claims := jwt.MapClaims{}
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	_, err = parser.ParseWithClaims(s.AccessToken, claims, func(*jwt.Token) (interface{}, error) {
+		return PublicKey(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	ttl := now.Add(jwtTTL)
+	delete(claims, mfaPendingClaim)
+	claims["amr"] = methods
+	claims["iat"] = now.Unix()
+	claims["exp"] = ttl.Unix()
+
+	tokenString, err := signClaims(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	s.AccessToken = tokenString
+	s.TTL = ttl
+	s.Pending = false
+	s.AuthMethods = methods
+	sessionUpdated(s)
+	log.Infof("Session of user %v elevated with methods %v", s.GetUserID(), methods)
+
+	return s, nil
+}
+
+// IsPending returns true if session waits for the second authentication factor
+func (s *Session) IsPending() bool {
+	return s.Pending
+}
@@ -0,0 +1,104 @@
+package sessionstore
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/getblank/blank-sr/config"
+	"github.com/golang-jwt/jwt"
+)
+
+// ErrIssuerNotConfigured returns when discovery document requested without issuerUrl server setting
+var ErrIssuerNotConfigured = errors.New("issuer url is not configured")
+
+// Introspect returns RFC 7662 token introspection response for the access token.
+// Token is active if its signature is valid, it is not expired and it is the current token of existing not pending session.
+func Introspect(token string) map[string]interface{} {
+	inactive := map[string]interface{}{"active": false}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return PublicKey(), nil
+	})
+	if err != nil {
+		return inactive
+	}
+
+	sessionID, _ := claims["sessionId"].(string)
+	s, err := getByAPIKey(sessionID)
+	if err != nil || s.IsPending() {
+		return inactive
+	}
+
+	// token issued before elevation is still valid JWT, but session has the new one
+	s.RLock()
+	current := s.AccessToken == token
+	s.RUnlock()
+	if !current {
+		return inactive
+	}
+
+	res := map[string]interface{}{}
+	for k, v := range claims {
+		res[k] = v
+	}
+	res["active"] = true
+	res["token_type"] = "Bearer"
+	res["sub"] = fmt.Sprint(claims["userId"])
+
+	return res
+}
+
+// Discovery returns OpenID Connect discovery document.
+// Issuer and endpoint URIs are taken from issuerUrl server setting.
+func Discovery() (map[string]interface{}, error) {
+	iss, err := config.IssuerURL()
+	if err != nil {
+		return nil, err
+	}
+	if iss == "" {
+		return nil, ErrIssuerNotConfigured
+	}
+	baseURL := strings.TrimSuffix(iss, "/")
+
+	return map[string]interface{}{
+		"issuer":                                iss,
+		"jwks_uri":                              baseURL + "/.well-known/jwks.json",
+		"introspection_endpoint":                baseURL + "/oauth/introspect",
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{jwt.SigningMethodRS256.Alg()},
+		"claims_supported":                      []string{"iss", "iat", "exp", "userId", "sessionId", "act", "amr"},
+	}, nil
+}
+
+// JWKS returns JSON Web Key Set with the public key used to sign tokens
+func JWKS() map[string]interface{} {
+	rsaLocker.RLock()
+	defer rsaLocker.RUnlock()
+
+	if publicKey == nil {
+		return map[string]interface{}{"keys": []interface{}{}}
+	}
+
+	key := map[string]interface{}{
+		"kty": "RSA",
+		"use": "sig",
+		"alg": jwt.SigningMethodRS256.Alg(),
+		"kid": keyID(publicKeyBytes),
+		"n":   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+	}
+
+	return map[string]interface{}{"keys": []interface{}{key}}
+}
+
+// keyID returns identifier of the public key for "kid" JWT header and JWKS
+func keyID(publicKeyPEM []byte) string {
+	sum := sha256.Sum256(publicKeyPEM)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:8])
+}
@@ -1,9 +1,20 @@
 package sessionstore
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
 	. "github.com/franela/goblin"
+
+	"github.com/getblank/blank-sr/berror"
+	"github.com/getblank/blank-sr/config"
+	"github.com/golang-jwt/jwt"
 )
 
 func TestSession(t *testing.T) {
@@ -75,7 +86,7 @@ func TestSession(t *testing.T) {
 				g.Assert(len(newS.Connections)).Equal(0)
 				var connID = "!!!"
 				var uri = "com.sub"
-				newS.AddSubscription(connID, uri, nil)
+				newS.AddSubscription("router", connID, uri, nil)
 				g.Assert(len(newS.Connections)).Equal(1)
 				g.Assert(len(newS.Connections[0].Subscriptions)).Equal(1)
 			})
@@ -88,7 +99,7 @@ func TestSession(t *testing.T) {
 				g.Assert(len(newS.Connections)).Equal(0)
 				var connID = "!!!"
 				var uri = "com.sub"
-				newS.AddSubscription(connID, uri, nil)
+				newS.AddSubscription("router", connID, uri, nil)
 				g.Assert(len(newS.Connections)).Equal(1)
 				newS.DeleteSubscription(connID, uri)
 				g.Assert(len(newS.Connections[0].Subscriptions)).Equal(0)
@@ -102,11 +113,449 @@ func TestSession(t *testing.T) {
 				g.Assert(len(newS.Connections)).Equal(0)
 				var connID = "!!!"
 				var uri = "com.sub"
-				newS.AddSubscription(connID, uri, nil)
+				newS.AddSubscription("router", connID, uri, nil)
 				g.Assert(len(newS.Connections)).Equal(1)
 				newS.DeleteConnection(connID)
 				g.Assert(len(newS.Connections)).Equal(0)
 			})
 		})
+
+		g.Describe("#DeleteConnectionsForOwner", func() {
+			var user = map[string]interface{}{"_id": "901"}
+			g.It("Should delete only connections of provided owner", func() {
+				newS := New(user, "")
+				newS.AddSubscription("router1", "conn1", "com.sub", nil)
+				newS.AddSubscription("router2", "conn2", "com.sub", nil)
+				g.Assert(len(newS.Connections)).Equal(2)
+				DeleteConnectionsForOwner("router1")
+				g.Assert(len(newS.Connections)).Equal(1)
+				g.Assert(newS.Connections[0].ConnID).Equal("conn2")
+				g.Assert(newS.Connections[0].Owner).Equal("router2")
+			})
+		})
+
+		g.Describe("#SetConnectionsForOwner", func() {
+			var user = map[string]interface{}{"_id": "912"}
+			g.It("Should replace connections of provided owner", func() {
+				newS := New(user, "")
+				newS.AddSubscription("router1", "conn1", "com.sub", nil)
+				newS.AddSubscription("router2", "conn2", "com.sub", nil)
+				notFound := SetConnectionsForOwner("router1", map[string][]*Conn{
+					newS.GetAPIKey(): {
+						{ConnID: "conn3", Subscriptions: map[string]interface{}{"com.sub": nil}},
+						{ConnID: "conn4"},
+					},
+					"unknownApiKey": {{ConnID: "conn5"}},
+				})
+				g.Assert(notFound).Equal([]string{"unknownApiKey"})
+				g.Assert(len(newS.Connections)).Equal(3)
+				g.Assert(newS.Connections[0].ConnID).Equal("conn2")
+				g.Assert(newS.Connections[1].ConnID).Equal("conn3")
+				g.Assert(newS.Connections[1].Owner).Equal("router1")
+				g.Assert(len(newS.Connections[2].Subscriptions)).Equal(0)
+			})
+		})
+
+		g.Describe("#Batch", func() {
+			g.It("Should apply all operations", func() {
+				s1 := New(map[string]interface{}{"_id": "923"}, "")
+				s2 := New(map[string]interface{}{"_id": "934"}, "")
+				s2.AddSubscription("router", "conn3", "com.sub", nil)
+				err := Batch("router", []Op{
+					{Type: OpSubscribed, APIKey: s1.GetAPIKey(), ConnID: "conn1", URI: "com.sub1"},
+					{Type: OpSubscribed, APIKey: s1.GetAPIKey(), ConnID: "conn1", URI: "com.sub2"},
+					{Type: OpUnsubscribed, APIKey: s1.GetAPIKey(), ConnID: "conn1", URI: "com.sub1"},
+					{Type: OpSubscribed, APIKey: s2.GetAPIKey(), ConnID: "conn2", URI: "com.sub"},
+					{Type: OpDeleteConnection, APIKey: s2.GetAPIKey(), ConnID: "conn3"},
+				})
+				g.Assert(err == nil).IsTrue()
+				g.Assert(len(s1.Connections)).Equal(1)
+				g.Assert(len(s1.Connections[0].Subscriptions)).Equal(1)
+				g.Assert(s1.Connections[0].Owner).Equal("router")
+				g.Assert(len(s2.Connections)).Equal(1)
+				g.Assert(s2.Connections[0].ConnID).Equal("conn2")
+			})
+			g.It("Should not apply any operation if one of them is invalid", func() {
+				s := New(map[string]interface{}{"_id": "945"}, "")
+				err := Batch("router", []Op{
+					{Type: OpSubscribed, APIKey: s.GetAPIKey(), ConnID: "conn1", URI: "com.sub"},
+					{Type: OpSubscribed, APIKey: "unknownApiKey", ConnID: "conn2", URI: "com.sub"},
+				})
+				g.Assert(err).Equal(berror.DbNotFound)
+				err = Batch("router", []Op{
+					{Type: OpSubscribed, APIKey: s.GetAPIKey(), ConnID: "conn1", URI: "com.sub"},
+					{Type: "unknown", APIKey: s.GetAPIKey(), ConnID: "conn1"},
+				})
+				g.Assert(err).Equal(ErrUnknownOperation)
+				g.Assert(len(s.Connections)).Equal(0)
+			})
+			g.It("Should not update session if nothing changed", func() {
+				s := New(map[string]interface{}{"_id": "946"}, "")
+				v := s.V
+				err := Batch("router", []Op{
+					{Type: OpDeleteConnection, APIKey: s.GetAPIKey(), ConnID: "unknownConn"},
+					{Type: OpUnsubscribed, APIKey: s.GetAPIKey(), ConnID: "unknownConn", URI: "com.sub"},
+				})
+				g.Assert(err == nil).IsTrue()
+				g.Assert(s.V).Equal(v)
+			})
+		})
+
+		g.Describe("#Impersonate", func() {
+			var admin = map[string]interface{}{"_id": "956"}
+			var user = map[string]interface{}{"_id": "967"}
+			g.It("Should create session with actor", func() {
+				adminS := New(admin, "")
+				s, err := Impersonate(adminS.GetAPIKey(), user)
+				g.Assert(err == nil).IsTrue()
+				g.Assert(s.GetUserID()).Equal("967")
+				g.Assert(s.IsImpersonated()).IsTrue()
+				g.Assert(s.Actor.UserID).Equal("956")
+				g.Assert(s.Actor.SessionID).Equal(adminS.GetAPIKey())
+				g.Assert(s.TTL.Before(adminS.TTL)).IsTrue()
+
+				claims := jwt.MapClaims{}
+				_, err = jwt.ParseWithClaims(s.AccessToken, claims, func(*jwt.Token) (interface{}, error) {
+					return PublicKey(), nil
+				})
+				g.Assert(err == nil).IsTrue()
+				act, ok := claims["act"].(map[string]interface{})
+				g.Assert(ok).IsTrue()
+				g.Assert(act["sub"]).Equal("956")
+			})
+			g.It("Should not allow nested impersonation", func() {
+				adminS := New(admin, "")
+				s, _ := Impersonate(adminS.GetAPIKey(), user)
+				_, err := Impersonate(s.GetAPIKey(), map[string]interface{}{"_id": "978"})
+				g.Assert(err).Equal(ErrNestedImpersonation)
+			})
+			g.It("Should not outlive actor session", func() {
+				adminS := New(admin, "")
+				adminS.TTL = time.Now().Add(time.Minute)
+				s, err := Impersonate(adminS.GetAPIKey(), user)
+				g.Assert(err == nil).IsTrue()
+				g.Assert(s.TTL.After(adminS.TTL)).IsFalse()
+
+				adminS.TTL = time.Now().Add(-time.Second)
+				_, err = Impersonate(adminS.GetAPIKey(), user)
+				g.Assert(err).Equal(ErrSessionExpired)
+			})
+		})
+
+		g.Describe("#EndImpersonation", func() {
+			var admin = map[string]interface{}{"_id": "989"}
+			var user = map[string]interface{}{"_id": "990"}
+			g.It("Should delete impersonated session and return actor session", func() {
+				adminS := New(admin, "")
+				s, _ := Impersonate(adminS.GetAPIKey(), user)
+				actorS, err := EndImpersonation(s.GetAPIKey())
+				g.Assert(err == nil).IsTrue()
+				g.Assert(actorS.GetAPIKey()).Equal(adminS.GetAPIKey())
+				_, err = GetByAPIKey(s.GetAPIKey())
+				g.Assert(err).Equal(berror.DbNotFound)
+			})
+			g.It("Should return error for not impersonated session", func() {
+				s := New(user, "")
+				_, err := EndImpersonation(s.GetAPIKey())
+				g.Assert(err).Equal(ErrNotImpersonated)
+			})
+		})
+
+		g.Describe("#NewPending", func() {
+			var user = map[string]interface{}{"_id": "1001"}
+			g.It("Should create pending session with restricted token", func() {
+				s := NewPending(user, "")
+				g.Assert(s.IsPending()).IsTrue()
+
+				claims := jwt.MapClaims{}
+				_, err := jwt.ParseWithClaims(s.AccessToken, claims, func(*jwt.Token) (interface{}, error) {
+					return PublicKey(), nil
+				})
+				g.Assert(err == nil).IsTrue()
+				g.Assert(claims[mfaPendingClaim]).Equal(true)
+			})
+			g.It("Should not allow impersonation from pending session", func() {
+				s := NewPending(user, "")
+				_, err := Impersonate(s.GetAPIKey(), map[string]interface{}{"_id": "1012"})
+				g.Assert(err).Equal(ErrSessionPending)
+			})
+		})
+
+		g.Describe("#Elevate", func() {
+			var user = map[string]interface{}{"_id": "1023"}
+			g.It("Should upgrade pending session and re-sign token", func() {
+				s := NewPending(user, "")
+				pendingTTL := s.TTL
+				pendingToken := s.AccessToken
+				_, err := Elevate(s.GetAPIKey(), []string{"pwd", "otp"})
+				g.Assert(err == nil).IsTrue()
+				g.Assert(s.IsPending()).IsFalse()
+				g.Assert(s.AuthMethods).Equal([]string{"pwd", "otp"})
+				g.Assert(s.TTL.After(pendingTTL)).IsTrue()
+				g.Assert(s.AccessToken != pendingToken).IsTrue()
+
+				claims := jwt.MapClaims{}
+				_, err = jwt.ParseWithClaims(s.AccessToken, claims, func(*jwt.Token) (interface{}, error) {
+					return PublicKey(), nil
+				})
+				g.Assert(err == nil).IsTrue()
+				g.Assert(claims[mfaPendingClaim] == nil).IsTrue()
+				g.Assert(claims["userId"]).Equal("1023")
+				g.Assert(claims["amr"]).Equal([]interface{}{"pwd", "otp"})
+			})
+			g.It("Should return error for not pending session", func() {
+				s := New(user, "")
+				_, err := Elevate(s.GetAPIKey(), []string{"otp"})
+				g.Assert(err).Equal(ErrNotPending)
+			})
+			g.It("Should return error for expired session", func() {
+				s := NewPending(user, "")
+				s.TTL = time.Now().Add(-time.Second)
+				_, err := Elevate(s.GetAPIKey(), []string{"otp"})
+				g.Assert(err).Equal(ErrSessionExpired)
+				g.Assert(s.IsPending()).IsTrue()
+			})
+		})
+
+		g.Describe("#SSO", func() {
+			var user = map[string]interface{}{"_id": "1034"}
+			var origin = "https://app.example.com"
+			g.Before(func() {
+				confFile, _ := ioutil.TempFile("", "config")
+				confFile.WriteString(`{"_serverSettings": {"type": "map", "entries": {"ssoOrigins": ["` + origin + `"]}}}`)
+				confFile.Close()
+				defer os.Remove(confFile.Name())
+				config.Init(confFile.Name())
+			})
+			g.It("Should exchange code for new session of the same user", func() {
+				source := New(user, "")
+				code, err := NewSSOCode(source.GetAPIKey(), origin)
+				g.Assert(err == nil).IsTrue()
+				s, err := ExchangeSSOCode(code, origin, "127.0.0.1")
+				g.Assert(err == nil).IsTrue()
+				g.Assert(s.GetUserID()).Equal("1034")
+				g.Assert(s.GetAPIKey() != source.GetAPIKey()).IsTrue()
+			})
+			g.It("Should allow to exchange code only once", func() {
+				source := New(user, "")
+				code, _ := NewSSOCode(source.GetAPIKey(), origin)
+				ExchangeSSOCode(code, origin, "127.0.0.1")
+				_, err := ExchangeSSOCode(code, origin, "127.0.0.1")
+				g.Assert(err).Equal(berror.DbNotFound)
+			})
+			g.It("Should reject not allowed origins", func() {
+				source := New(user, "")
+				_, err := NewSSOCode(source.GetAPIKey(), "https://evil.example.com")
+				g.Assert(err).Equal(ErrOriginNotAllowed)
+				code, _ := NewSSOCode(source.GetAPIKey(), origin)
+				_, err = ExchangeSSOCode(code, "https://evil.example.com", "127.0.0.1")
+				g.Assert(err).Equal(ErrOriginNotAllowed)
+			})
+			g.It("Should reject impersonated sessions", func() {
+				admin := New(map[string]interface{}{"_id": "1045"}, "")
+				s, _ := Impersonate(admin.GetAPIKey(), user)
+				_, err := NewSSOCode(s.GetAPIKey(), origin)
+				g.Assert(err).Equal(ErrSSONotAllowed)
+			})
+		})
+
+		g.Describe("#Introspect", func() {
+			var user = map[string]interface{}{"_id": "1056"}
+			g.It("Should return active with claims for valid token", func() {
+				s := New(user, "")
+				res := Introspect(s.AccessToken)
+				g.Assert(res["active"]).Equal(true)
+				g.Assert(res["sub"]).Equal("1056")
+				g.Assert(res["sessionId"]).Equal(s.GetAPIKey())
+			})
+			g.It("Should return inactive for deleted session, pending session and garbage", func() {
+				s := New(user, "")
+				s.Delete()
+				g.Assert(Introspect(s.AccessToken)).Equal(map[string]interface{}{"active": false})
+				g.Assert(Introspect(NewPending(user, "").AccessToken)["active"]).Equal(false)
+				g.Assert(Introspect("garbage")["active"]).Equal(false)
+			})
+			g.It("Should return inactive for token issued before elevation", func() {
+				s := NewPending(user, "")
+				pendingToken := s.AccessToken
+				Elevate(s.GetAPIKey(), []string{"otp"})
+				g.Assert(Introspect(pendingToken)["active"]).Equal(false)
+				g.Assert(Introspect(s.AccessToken)["active"]).Equal(true)
+			})
+		})
+
+		g.Describe("#JWKS", func() {
+			g.It("Should return public key matching token kid", func() {
+				s := New(map[string]interface{}{"_id": "1067"}, "")
+				token, _ := jwt.Parse(s.AccessToken, func(*jwt.Token) (interface{}, error) {
+					return PublicKey(), nil
+				})
+				keys := JWKS()["keys"].([]interface{})
+				g.Assert(len(keys)).Equal(1)
+				key := keys[0].(map[string]interface{})
+				g.Assert(key["kid"]).Equal(token.Header["kid"])
+				g.Assert(key["e"]).Equal("AQAB")
+			})
+		})
+
+		g.Describe("#Discovery", func() {
+			var issuer = "https://auth.example.com"
+			g.Before(func() {
+				confFile, _ := ioutil.TempFile("", "config")
+				confFile.WriteString(`{"_serverSettings": {"type": "map", "entries": {"issuerUrl": "` + issuer + `"}}}`)
+				confFile.Close()
+				defer os.Remove(confFile.Name())
+				config.Init(confFile.Name())
+			})
+			g.It("Should build document from issuer URL", func() {
+				doc, err := Discovery()
+				g.Assert(err == nil).IsTrue()
+				g.Assert(doc["issuer"]).Equal(issuer)
+				g.Assert(doc["jwks_uri"]).Equal(issuer + "/.well-known/jwks.json")
+				g.Assert(doc["introspection_endpoint"]).Equal(issuer + "/oauth/introspect")
+				g.Assert(doc["id_token_signing_alg_values_supported"]).Equal([]string{"RS256"})
+			})
+			g.It("Should issue tokens with issuer URL", func() {
+				s := New(map[string]interface{}{"_id": "1070"}, "")
+				claims := jwt.MapClaims{}
+				jwt.ParseWithClaims(s.AccessToken, claims, func(*jwt.Token) (interface{}, error) {
+					return PublicKey(), nil
+				})
+				g.Assert(claims["iss"]).Equal(issuer)
+			})
+		})
+
+		g.Describe("#Export", func() {
+			g.It("Should export sessions of the user as JSON lines", func() {
+				New(map[string]interface{}{"_id": "1078"}, "")
+				New(map[string]interface{}{"_id": "1078"}, "")
+				New(map[string]interface{}{"_id": "1089"}, "")
+				buf := bytes.NewBuffer(nil)
+				count, err := Export(buf, ExportFilter{UserID: "1078"})
+				g.Assert(err == nil).IsTrue()
+				g.Assert(count).Equal(2)
+				g.Assert(len(strings.Split(strings.TrimSpace(buf.String()), "\n"))).Equal(2)
+			})
+			g.It("Should filter sessions by age", func() {
+				s := New(map[string]interface{}{"_id": "1090"}, "")
+				s.CreatedAt = time.Now().Add(-time.Hour * 2)
+				s.Save()
+				buf := bytes.NewBuffer(nil)
+				count, _ := Export(buf, ExportFilter{UserID: "1090", MaxAge: time.Hour})
+				g.Assert(count).Equal(0)
+			})
+		})
+
+		g.Describe("#Import", func() {
+			g.It("Should import valid sessions and skip invalid, expired and existing ones", func() {
+				s := New(map[string]interface{}{"_id": "1101"}, "")
+				buf := bytes.NewBuffer(nil)
+				Export(buf, ExportFilter{UserID: "1101"})
+				exported := buf.String()
+				Delete(s.GetAPIKey())
+
+				expired := copySession(s)
+				expired.APIKey = "expiredSession"
+				expired.TTL = time.Now().Add(-time.Second)
+				encodedExpired, _ := json.Marshal(expired)
+				forged := copySession(s)
+				forged.APIKey = "forgedSession"
+				encodedForged, _ := json.Marshal(forged)
+
+				data := exported + string(encodedExpired) + "\n" + string(encodedForged) + "\n" + "garbage\n"
+				imported, skipped, err := Import(strings.NewReader(data))
+				g.Assert(err == nil).IsTrue()
+				g.Assert(imported).Equal(1)
+				g.Assert(skipped).Equal(3)
+				restored, err := GetByAPIKey(s.GetAPIKey())
+				g.Assert(err == nil).IsTrue()
+				g.Assert(restored.GetUserID()).Equal("1101")
+
+				imported, skipped, _ = Import(strings.NewReader(exported))
+				g.Assert(imported).Equal(0)
+				g.Assert(skipped).Equal(1)
+			})
+			g.It("Should skip records that don't match their token", func() {
+				admin := New(map[string]interface{}{"_id": "1102"}, "")
+				s, _ := Impersonate(admin.GetAPIKey(), map[string]interface{}{"_id": "1103"})
+				buf := bytes.NewBuffer(nil)
+				Export(buf, ExportFilter{UserID: "1103"})
+				Delete(s.GetAPIKey())
+
+				var exported Session
+				json.Unmarshal(buf.Bytes(), &exported)
+				otherUser := copySession(&exported)
+				otherUser.UserID = "1104"
+				longerTTL := copySession(&exported)
+				longerTTL.TTL = longerTTL.TTL.Add(time.Hour)
+				pending := copySession(&exported)
+				pending.Pending = true
+				otherActor := copySession(&exported)
+				otherActor.Actor = &Actor{UserID: "1104", SessionID: admin.GetAPIKey()}
+				noActor := copySession(&exported)
+				noActor.Actor = nil
+
+				for _, forged := range []*Session{otherUser, longerTTL, pending, otherActor, noActor} {
+					encoded, _ := json.Marshal(forged)
+					imported, skipped, _ := Import(bytes.NewReader(encoded))
+					g.Assert(imported).Equal(0)
+					g.Assert(skipped).Equal(1)
+				}
+
+				imported, _, _ := Import(buf)
+				g.Assert(imported).Equal(1)
+			})
+		})
+
+		g.Describe("#Encryption", func() {
+			key1 := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+			key2 := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))
+			g.After(func() {
+				setEncryptionKeys("")
+			})
+			g.It("Should store sessions encrypted with the current key", func() {
+				g.Assert(setEncryptionKeys("1:"+key1) == nil).IsTrue()
+				s := New(map[string]interface{}{"_id": "1112"}, "")
+				raw, _ := db.Get(bucket, s.GetAPIKey())
+				g.Assert(bytes.HasPrefix(raw, []byte("enc:1:"))).IsTrue()
+				g.Assert(bytes.Contains(raw, []byte(s.AccessToken))).IsFalse()
+				decrypted, err := decrypt(s.GetAPIKey(), raw)
+				g.Assert(err == nil).IsTrue()
+				g.Assert(bytes.Contains(decrypted, []byte(s.AccessToken))).IsTrue()
+			})
+			g.It("Should re-encrypt sessions with the new key on load", func() {
+				setEncryptionKeys("1:" + key1)
+				s := New(map[string]interface{}{"_id": "1123"}, "")
+				g.Assert(setEncryptionKeys("1:"+key1+",2:"+key2) == nil).IsTrue()
+				raw, _ := db.Get(bucket, s.GetAPIKey())
+				g.Assert(needsReencryption(raw)).IsTrue()
+				loadSessions()
+				raw, _ = db.Get(bucket, s.GetAPIKey())
+				g.Assert(bytes.HasPrefix(raw, []byte("enc:2:"))).IsTrue()
+				_, err := GetByAPIKey(s.GetAPIKey())
+				g.Assert(err == nil).IsTrue()
+			})
+			g.It("Should fail to decrypt records of unknown key version", func() {
+				setEncryptionKeys("2:" + key2)
+				s := New(map[string]interface{}{"_id": "1134"}, "")
+				raw, _ := db.Get(bucket, s.GetAPIKey())
+				setEncryptionKeys("1:" + key1)
+				_, err := decrypt(s.GetAPIKey(), raw)
+				g.Assert(err).Equal(errUnknownKeyVersion)
+			})
+			g.It("Should fail to decrypt record stored by another key", func() {
+				setEncryptionKeys("1:" + key1)
+				s := New(map[string]interface{}{"_id": "1145"}, "")
+				raw, _ := db.Get(bucket, s.GetAPIKey())
+				_, err := decrypt(s.GetAPIKey(), raw)
+				g.Assert(err == nil).IsTrue()
+				_, err = decrypt("forgedSession", raw)
+				g.Assert(err == nil).IsFalse()
+			})
+			g.It("Should reject invalid keys", func() {
+				g.Assert(setEncryptionKeys("1:short") == nil).IsFalse()
+				g.Assert(setEncryptionKeys(key1) == nil).IsFalse()
+			})
+		})
 	})
 }
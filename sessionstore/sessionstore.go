@@ -6,8 +6,10 @@ import (
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"io/ioutil"
 	"os"
+	"sort"
 	"sync"
 	"time"
 
@@ -43,11 +45,20 @@ func PublicKeyBytes() []byte {
 
 const keysDir = "keys"
 
+// Issuer is the value of "iss" claim of JWT issued by sessionstore if issuer URL is not configured
+const Issuer = "Blank ltd"
+
+// ErrUnknownOperation returns by Batch when operation type is not supported
+var ErrUnknownOperation = errors.New("unknown operation")
+
 // Session represents user session in Blank
 type Session struct {
 	APIKey      string      `json:"apiKey"`
 	AccessToken string      `json:"access_token,omitempty"`
 	UserID      interface{} `json:"userId"`
+	Actor       *Actor      `json:"actor,omitempty"`   // not nil for sessions created by impersonation
+	Pending     bool        `json:"pending,omitempty"` // session waits for the second authentication factor
+	AuthMethods []string    `json:"amr,omitempty"`
 	Connections []*Conn     `json:"connections"`
 	CreatedAt   time.Time   `json:"createdAt"`
 	LastRequest time.Time   `json:"lastRequest"`
@@ -59,12 +70,32 @@ type Session struct {
 // Conn represents WAMP connection in session
 type Conn struct {
 	ConnID        string                 `json:"connId"`
+	Owner         string                 `json:"owner,omitempty"` // ID of the registry instance (router) holding the connection
 	Subscriptions map[string]interface{} `json:"subscriptions"`
 }
 
+// Operation types for Batch
+const (
+	OpSubscribed       = "subscribed"
+	OpUnsubscribed     = "unsubscribed"
+	OpDeleteConnection = "delete-connection"
+)
+
+// Op represents single operation applied by Batch
+type Op struct {
+	Type   string
+	APIKey string
+	ConnID string
+	URI    string
+	Extra  interface{}
+}
+
 // Init is the entrypoint of sessionstore
 func Init() {
 	initRSAKeys()
+	if err := LoadEncryptionKeys(); err != nil {
+		log.Fatal("Can't load sessions encryption keys", err)
+	}
 
 	loadSessions()
 	go ttlWatcher()
@@ -72,21 +103,47 @@ func Init() {
 
 // New created new user session.
 func New(user map[string]interface{}, sessionID string) *Session {
+	jwtTTL, err := config.JWTTTL()
+	if err != nil {
+		log.WithError(err).Error("Can't get JWT TTL. Will setup 24 hours")
+		jwtTTL = time.Hour * 24
+	}
+
+	return newSession(user, sessionID, sessionOptions{lifetime: jwtTTL})
+}
+
+type sessionOptions struct {
+	lifetime time.Duration
+	notAfter time.Time // session never outlives it if not zero
+	actor    *Actor
+	pending  bool
+}
+
+func issuer() string {
+	iss, err := config.IssuerURL()
+	if err != nil {
+		log.WithError(err).Error("Can't get issuer URL. Will use default issuer")
+	}
+	if iss == "" {
+		return Issuer
+	}
+
+	return iss
+}
+
+func newSession(user map[string]interface{}, sessionID string, opts sessionOptions) *Session {
 	userID := user["_id"]
 	if len(sessionID) == 0 {
 		sessionID = uuid.NewV4()
 	}
 
 	now := time.Now()
-	jwtTTL, err := config.JWTTTL()
-	if err != nil {
-		log.WithError(err).Error("Can't get JWT TTL. Will setup 24 hours")
-		jwtTTL = time.Hour * 24
+	ttl := now.Add(opts.lifetime)
+	if !opts.notAfter.IsZero() && ttl.After(opts.notAfter) {
+		ttl = opts.notAfter
 	}
-
-	ttl := now.Add(jwtTTL)
 	claims := jwt.MapClaims{
-		"iss":       "Blank ltd",
+		"iss":       issuer(),
 		"iat":       now.Unix(),
 		"exp":       ttl.Unix(),
 		"userId":    userID,
@@ -99,8 +156,18 @@ func New(user map[string]interface{}, sessionID string) *Session {
 		}
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-	tokenString, err := token.SignedString(privateKey)
+	if opts.actor != nil {
+		claims["act"] = map[string]interface{}{
+			"sub":       opts.actor.UserID,
+			"sessionId": opts.actor.SessionID,
+		}
+	}
+
+	if opts.pending {
+		claims[mfaPendingClaim] = true
+	}
+
+	tokenString, err := signClaims(claims)
 	if err != nil {
 		log.Fatal("Can't sign JWT")
 	}
@@ -109,6 +176,8 @@ func New(user map[string]interface{}, sessionID string) *Session {
 		APIKey:      sessionID,
 		AccessToken: tokenString,
 		UserID:      userID,
+		Actor:       opts.actor,
+		Pending:     opts.pending,
 		Connections: []*Conn{},
 		TTL:         ttl,
 		CreatedAt:   time.Now(),
@@ -134,6 +203,99 @@ func DeleteAllConnections() {
 	}
 }
 
+// Batch applies provided operations to sessions atomically.
+// Operations are validated before applying: if any of them refers to unknown session
+// or has unknown type, no changes are made and error returned.
+// Every affected session is saved and published once.
+func Batch(owner string, ops []Op) error {
+	locker.RLock()
+	defer locker.RUnlock()
+
+	affected := map[string]*Session{}
+	for _, op := range ops {
+		switch op.Type {
+		case OpSubscribed, OpUnsubscribed, OpDeleteConnection:
+		default:
+			return ErrUnknownOperation
+		}
+
+		s, ok := sessions[op.APIKey]
+		if !ok {
+			return berror.DbNotFound
+		}
+		affected[op.APIKey] = s
+	}
+
+	// sessions are locked in order of apiKeys to prevent deadlock with concurrent batches
+	apiKeys := make([]string, 0, len(affected))
+	for apiKey := range affected {
+		apiKeys = append(apiKeys, apiKey)
+	}
+	sort.Strings(apiKeys)
+	for _, apiKey := range apiKeys {
+		affected[apiKey].Lock()
+	}
+
+	changed := map[string]bool{}
+	for _, op := range ops {
+		s := affected[op.APIKey]
+		switch op.Type {
+		case OpSubscribed:
+			s.addSubscription(owner, op.ConnID, op.URI, op.Extra)
+			changed[op.APIKey] = true
+		case OpUnsubscribed:
+			if s.deleteSubscription(op.ConnID, op.URI) {
+				changed[op.APIKey] = true
+			}
+		case OpDeleteConnection:
+			if s.deleteConnection(op.ConnID) {
+				changed[op.APIKey] = true
+			}
+		}
+	}
+
+	for _, apiKey := range apiKeys {
+		s := affected[apiKey]
+		if changed[apiKey] {
+			sessionUpdated(s)
+		}
+		s.Unlock()
+	}
+
+	return nil
+}
+
+// DeleteConnectionsForOwner deletes connections held by owner from all sessions.
+// Connections of other owners stay untouched.
+func DeleteConnectionsForOwner(owner string) {
+	locker.RLock()
+	defer locker.RUnlock()
+
+	for _, s := range sessions {
+		s.deleteConnectionsForOwner(owner)
+	}
+}
+
+// SetConnectionsForOwner replaces all connections held by owner with provided ones.
+// conns is a map of session apiKey to the owner's connections in that session.
+// Returns apiKeys from conns that have no session in store.
+func SetConnectionsForOwner(owner string, conns map[string][]*Conn) (notFound []string) {
+	locker.RLock()
+	defer locker.RUnlock()
+
+	for apiKey := range conns {
+		if _, ok := sessions[apiKey]; !ok {
+			notFound = append(notFound, apiKey)
+		}
+	}
+
+	for apiKey, s := range sessions {
+		s.setConnectionsForOwner(owner, conns[apiKey])
+	}
+
+	return notFound
+}
+
 // GetAll returns all stored sessions
 func GetAll() []*Session {
 	result := make([]*Session, len(sessions))
@@ -188,63 +350,128 @@ func DeleteAllForUser(userID string) {
 	}
 }
 
-// AddSubscription adds subscription URI with provided params to user session
-func (s *Session) AddSubscription(connID, uri string, extra interface{}) {
+// AddSubscription adds subscription URI with provided params to user session.
+// owner is the ID of the registry instance holding the connection.
+func (s *Session) AddSubscription(owner, connID, uri string, extra interface{}) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.addSubscription(owner, connID, uri, extra)
+	sessionUpdated(s)
+}
+
+// DeleteConnection deletes WAMP connection from user session
+func (s *Session) DeleteConnection(connID string) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.deleteConnection(connID)
+	sessionUpdated(s)
+}
+
+// DeleteSubscription deletes subscription from connection of user session
+func (s *Session) DeleteSubscription(connID, uri string) {
 	s.Lock()
 	defer s.Unlock()
 
-	var c *Conn
-	for _, _c := range s.Connections {
-		if _c.ConnID == connID {
-			c = _c
-			break
+	if !s.deleteSubscription(connID, uri) {
+		return
+	}
+
+	sessionUpdated(s)
+}
+
+func (s *Session) getConnection(connID string) *Conn {
+	for _, c := range s.Connections {
+		if c.ConnID == connID {
+			return c
 		}
 	}
 
+	return nil
+}
+
+func (s *Session) addSubscription(owner, connID, uri string, extra interface{}) {
+	c := s.getConnection(connID)
 	if c == nil {
 		c = new(Conn)
 		c.ConnID = connID
+		c.Owner = owner
 		c.Subscriptions = map[string]interface{}{}
 		s.Connections = append(s.Connections, c)
 	}
 
 	c.Subscriptions[uri] = extra
-	sessionUpdated(s)
 }
 
-// DeleteConnection deletes WAMP connection from user session
-func (s *Session) DeleteConnection(connID string) {
+func (s *Session) deleteConnection(connID string) bool {
+	for i, c := range s.Connections {
+		if c.ConnID == connID {
+			s.Connections = append(s.Connections[:i], s.Connections[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Session) deleteSubscription(connID, uri string) bool {
+	c := s.getConnection(connID)
+	if c == nil {
+		return false
+	}
+
+	delete(c.Subscriptions, uri)
+	return true
+}
+
+func (s *Session) deleteConnectionsForOwner(owner string) {
 	s.Lock()
 	defer s.Unlock()
 
-	for i, _c := range s.Connections {
-		if _c.ConnID == connID {
-			s.Connections = append(s.Connections[:i], s.Connections[i+1:]...)
-			break
+	conns := make([]*Conn, 0, len(s.Connections))
+	for _, c := range s.Connections {
+		if c.Owner != owner {
+			conns = append(conns, c)
 		}
 	}
 
+	if len(conns) == len(s.Connections) {
+		return
+	}
+
+	s.Connections = conns
 	sessionUpdated(s)
 }
 
-// DeleteSubscription deletes subscription from connection of user session
-func (s *Session) DeleteSubscription(connID, uri string) {
+func (s *Session) setConnectionsForOwner(owner string, ownerConns []*Conn) {
 	s.Lock()
 	defer s.Unlock()
 
-	var c *Conn
-	for _, _c := range s.Connections {
-		if _c.ConnID == connID {
-			c = _c
-			break
+	var changed bool
+	conns := make([]*Conn, 0, len(s.Connections)+len(ownerConns))
+	for _, c := range s.Connections {
+		if c.Owner == owner {
+			changed = true
+			continue
+		}
+		conns = append(conns, c)
+	}
+
+	for _, c := range ownerConns {
+		c.Owner = owner
+		if c.Subscriptions == nil {
+			c.Subscriptions = map[string]interface{}{}
 		}
+		conns = append(conns, c)
+		changed = true
 	}
 
-	if c == nil {
+	if !changed {
 		return
 	}
 
-	delete(c.Subscriptions, uri)
+	s.Connections = conns
 	sessionUpdated(s)
 }
 
@@ -265,9 +492,21 @@ func (s *Session) Delete() {
 // Save saves session in store
 func (s *Session) Save() {
 	s = copySession(s)
-	err := db.Save(bucket, s.APIKey, s)
+	encoded, err := json.Marshal(s)
 	if err != nil {
-		log.Error("Can't save session", s, err.Error())
+		log.Error("Can't marshal session", s.APIKey, err.Error())
+		return
+	}
+
+	encoded, err = encrypt(s.APIKey, encoded)
+	if err != nil {
+		log.Error("Can't encrypt session", s.APIKey, err.Error())
+		return
+	}
+
+	err = db.Save(bucket, s.APIKey, encoded)
+	if err != nil {
+		log.Error("Can't save session", s.APIKey, err.Error())
 	}
 }
 
@@ -344,7 +583,7 @@ func clearRottenSessions() {
 }
 
 func loadSessions() {
-	_sessions, err := db.GetAll(bucket)
+	apiKeys, err := db.GetAllKeys(bucket)
 	if err != nil && err != berror.DbNotFound {
 		log.Error("Can't read all sessions", err.Error())
 
@@ -352,11 +591,24 @@ func loadSessions() {
 	}
 
 	now := time.Now()
+	var reencrypted int
 	locker.Lock()
 	defer locker.Unlock()
-	for _, _s := range _sessions {
+	for _, apiKey := range apiKeys {
+		encrypted, err := db.Get(bucket, apiKey)
+		if err != nil {
+			log.Error("Can't read session", apiKey, err.Error())
+			continue
+		}
+
+		_s, err := decrypt(apiKey, encrypted)
+		if err != nil {
+			log.Error("Can't decrypt session", apiKey, err.Error())
+			continue
+		}
+
 		var s Session
-		err := json.Unmarshal(_s, &s)
+		err = json.Unmarshal(_s, &s)
 		if err != nil {
 			log.Error("Can't unmarshal session", _s, err.Error())
 			continue
@@ -370,10 +622,18 @@ func loadSessions() {
 			continue
 		}
 
+		// saving every loaded session also re-encrypts it with the current key
+		if needsReencryption(encrypted) {
+			reencrypted++
+		}
 		s.Connections = []*Conn{}
 		s.Save()
 		sessions[s.APIKey] = &s
 	}
+
+	if reencrypted > 0 {
+		log.Infof("%d sessions re-encrypted with the current key", reencrypted)
+	}
 }
 
 func ttlWatcher() {
@@ -415,14 +675,18 @@ func copySession(s *Session) *Session {
 		APIKey:      s.APIKey,
 		AccessToken: s.AccessToken,
 		UserID:      s.UserID,
+		Actor:       s.Actor,
+		Pending:     s.Pending,
+		AuthMethods: s.AuthMethods,
 		Connections: make([]*Conn, len(s.Connections)),
+		CreatedAt:   s.CreatedAt,
 		LastRequest: s.LastRequest,
 		TTL:         s.TTL,
 		V:           s.V,
 	}
 
 	for i := range _s.Connections {
-		c := &Conn{ConnID: s.Connections[i].ConnID}
+		c := &Conn{ConnID: s.Connections[i].ConnID, Owner: s.Connections[i].Owner}
 		c.Subscriptions = map[string]interface{}{}
 		for k, v := range s.Connections[i].Subscriptions {
 			c.Subscriptions[k] = v
@@ -434,6 +698,43 @@ func copySession(s *Session) *Session {
 	return _s
 }
 
+func signClaims(claims jwt.MapClaims) (string, error) {
+	rsaLocker.RLock()
+	defer rsaLocker.RUnlock()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = keyID(publicKeyBytes)
+
+	return token.SignedString(privateKey)
+}
+
+// LoadKeys loads existing RSA keys from keys dir. Unlike Init it never generates new keys.
+func LoadKeys() error {
+	public, private, err := loadRSAKeys()
+	if err != nil {
+		return err
+	}
+
+	pubKey, err := jwt.ParseRSAPublicKeyFromPEM(public)
+	if err != nil {
+		return err
+	}
+
+	privKey, err := jwt.ParseRSAPrivateKeyFromPEM(private)
+	if err != nil {
+		return err
+	}
+
+	rsaLocker.Lock()
+	defer rsaLocker.Unlock()
+
+	publicKeyBytes = public
+	publicKey = pubKey
+	privateKey = privKey
+
+	return nil
+}
+
 func initRSAKeys() {
 	rsaLocker.Lock()
 	defer rsaLocker.Unlock()
@@ -0,0 +1,124 @@
+package sessionstore
+
+import (
+	"errors"
+
+	"github.com/getblank/blank-sr/config"
+	"github.com/getblank/blank-sr/tokenstore"
+	"github.com/golang-jwt/jwt"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrOriginNotAllowed returns when origin is not listed in ssoOrigins server setting
+	ErrOriginNotAllowed = errors.New("origin is not allowed for SSO")
+	// ErrSSONotAllowed returns when SSO code requested from impersonated session
+	ErrSSONotAllowed = errors.New("SSO is not allowed for impersonated session")
+)
+
+// NewSSOCode creates one-time code bound to origin for the user of session provided.
+// Code can be exchanged for the new session of the same user by ExchangeSSOCode.
+func NewSSOCode(apiKey, origin string) (string, error) {
+	if !ssoOriginAllowed(origin) {
+		return "", ErrOriginNotAllowed
+	}
+
+	s, err := getByAPIKey(apiKey)
+	if err != nil {
+		return "", err
+	}
+
+	if s.IsPending() {
+		return "", ErrSessionPending
+	}
+
+	if s.IsImpersonated() {
+		return "", ErrSSONotAllowed
+	}
+
+	t, err := tokenstore.Issue(tokenstore.PurposeSSO, s.GetUserID(), map[string]interface{}{
+		"origin":    origin,
+		"sessionId": s.GetAPIKey(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return t.Token, nil
+}
+
+// ExchangeSSOCode consumes one-time code and creates new session for the user the code was issued for.
+// origin must be the same the code was bound to. remoteAddr is used for audit only.
+func ExchangeSSOCode(code, origin, remoteAddr string) (*Session, error) {
+	auditLog := log.WithFields(log.Fields{
+		"audit":      "sso",
+		"origin":     origin,
+		"remoteAddr": remoteAddr,
+	})
+
+	t, err := tokenstore.Consume(tokenstore.PurposeSSO, code)
+	if err != nil {
+		auditLog.WithError(err).Warn("SSO code exchange rejected")
+		return nil, err
+	}
+
+	data, _ := t.Data.(map[string]interface{})
+	codeOrigin, _ := data["origin"].(string)
+	sourceSessionID, _ := data["sessionId"].(string)
+	auditLog = auditLog.WithFields(log.Fields{"userId": t.UserID, "sourceSessionId": sourceSessionID})
+	if codeOrigin != origin || !ssoOriginAllowed(origin) {
+		auditLog.Warn("SSO code exchange rejected: origin mismatch")
+		return nil, ErrOriginNotAllowed
+	}
+
+	source, err := getByAPIKey(sourceSessionID)
+	if err != nil {
+		auditLog.WithError(err).Warn("SSO code exchange rejected: source session not found")
+		return nil, err
+	}
+
+	user, err := source.userFromToken()
+	if err != nil {
+		auditLog.WithError(err).Error("SSO code exchange failed: can't parse source session token")
+		return nil, err
+	}
+
+	s := New(user, "")
+	auditLog.WithField("sessionId", s.GetAPIKey()).Info("SSO code exchanged")
+
+	return s, nil
+}
+
+// userFromToken restores user props placed into JWT when session was created.
+func (s *Session) userFromToken() (map[string]interface{}, error) {
+	s.RLock()
+	token := s.AccessToken
+	s.RUnlock()
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return PublicKey(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	user := map[string]interface{}{"_id": s.GetUserID()}
+	for _, k := range config.JWTExtraProps() {
+		if claims[k] != nil {
+			user[k] = claims[k]
+		}
+	}
+
+	return user, nil
+}
+
+func ssoOriginAllowed(origin string) bool {
+	for _, o := range config.SSOOrigins() {
+		if o == origin {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,91 @@
+package sync
+
+import (
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	barriers = map[string]*barrier{}
+
+	// ErrInvalidParties returns when barrier parties are not positive or differ from parties of the waiting owners
+	ErrInvalidParties = errors.New("invalid barrier parties")
+	// ErrAlreadyArrived returns when owner arrives at the barrier it already waits at
+	ErrAlreadyArrived = errors.New("owner already arrived at barrier")
+)
+
+type barrier struct {
+	parties  int
+	arrived  map[string]*waiter
+	released chan struct{}
+}
+
+// Barrier waits until parties owners arrive at the barrier with provided name, then releases all of them.
+// Barrier is reset after release, so it can be used again. Every owner is counted once.
+// Owner disconnected while waiting departs the barrier and is not counted anymore.
+// Zero timeout means no timeout. Returns ErrLockTimeout if timeout reached or ErrLockCancelled if owner was disconnected.
+func Barrier(owner, name string, parties int, timeout time.Duration) error {
+	log.Debugf("barrier id: %s ARRIVED owner %s, parties %d", name, owner, parties)
+	if parties <= 0 {
+		return ErrInvalidParties
+	}
+
+	mutexLocker.Lock()
+	defer mutexLocker.Unlock()
+	b, ok := barriers[name]
+	if !ok {
+		b = &barrier{parties: parties, arrived: map[string]*waiter{}, released: make(chan struct{})}
+		barriers[name] = b
+	}
+	if b.parties != parties {
+		return ErrInvalidParties
+	}
+	if _, ok := b.arrived[owner]; ok {
+		return ErrAlreadyArrived
+	}
+
+	if len(b.arrived)+1 == b.parties {
+		close(b.released)
+		delete(barriers, name)
+		log.Debugf("barrier id: %s RELEASED %d parties", name, b.parties)
+		return nil
+	}
+
+	w := addWaiter(owner, name, waitBarrier)
+	defer removeWaiter(owner, w)
+	b.arrived[owner] = w
+	var timer <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+
+	mutexLocker.Unlock()
+	select {
+	case <-b.released:
+	case <-w.cancel:
+	case <-timer:
+	}
+	mutexLocker.Lock()
+
+	select {
+	case <-b.released:
+		return nil
+	default:
+	}
+
+	delete(b.arrived, owner)
+	if len(b.arrived) == 0 && barriers[name] == b {
+		delete(barriers, name)
+	}
+	if w.cancelled {
+		log.Debugf("barrier id: %s DEPARTED owner %s", name, owner)
+		return ErrLockCancelled
+	}
+	log.Debugf("barrier id: %s TIMEOUT for owner %s", name, owner)
+
+	return ErrLockTimeout
+}
@@ -0,0 +1,44 @@
+package sync
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+)
+
+func TestBarrier(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("#Barrier", func() {
+		g.It("should release all parties when the last one arrives", func(done Done) {
+			name := "14142"
+			released := make(chan error, 2)
+			go func() { released <- Barrier("14124", name, 3, 0) }()
+			go func() { released <- Barrier("14125", name, 3, 0) }()
+			time.Sleep(time.Millisecond * 20)
+			g.Assert(Barrier("14125", name, 3, 0)).Equal(ErrAlreadyArrived)
+			g.Assert(Barrier("14126", name, 2, 0)).Equal(ErrInvalidParties)
+			g.Assert(Barrier("14126", name, 3, 0)).Equal(nil)
+			g.Assert(<-released).Equal(nil)
+			g.Assert(<-released).Equal(nil)
+			g.Assert(barriers[name] == nil).IsTrue()
+			done()
+		})
+		g.It("should not count owner that timed out", func() {
+			name := "14242"
+			g.Assert(Barrier("14224", name, 2, time.Millisecond*10)).Equal(ErrLockTimeout)
+			g.Assert(barriers[name] == nil).IsTrue()
+		})
+		g.It("should not count disconnected owner", func(done Done) {
+			name := "14342"
+			go func() {
+				g.Assert(Barrier("14324", name, 2, 0)).Equal(ErrLockCancelled)
+				g.Assert(Barrier("14325", name, 2, time.Millisecond*10)).Equal(ErrLockTimeout)
+				done()
+			}()
+			time.Sleep(time.Millisecond * 20)
+			UnlockForOwner("14324")
+		})
+	})
+}
@@ -0,0 +1,96 @@
+package sync
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DeadlockError returns when lock wait would close a cycle in the wait-for graph.
+// Cycle starts with the wait that was rejected.
+type DeadlockError struct {
+	Cycle []WaitEdge `json:"cycle"`
+}
+
+// WaitEdge is the wait of the owner for the lock held by blocker
+type WaitEdge struct {
+	Owner   string `json:"owner"`
+	LockID  string `json:"lockId"`
+	Blocker string `json:"blocker"`
+}
+
+func (e *DeadlockError) Error() string {
+	waits := make([]string, len(e.Cycle))
+	for i, edge := range e.Cycle {
+		waits[i] = fmt.Sprintf("owner %s waits for lock %s held by %s", edge.Owner, edge.LockID, edge.Blocker)
+	}
+
+	return "deadlock detected: " + strings.Join(waits, ", ")
+}
+
+// detectDeadlock returns DeadlockError if wait of the owner for the lock id would close a cycle.
+// Only waits for locks are taken into account. mutexLocker must be locked.
+func detectDeadlock(owner, id string, kind waitKind) error {
+	visited := map[string]bool{}
+	var visit func(waiting, id string, kind waitKind) []WaitEdge
+	visit = func(waiting, id string, kind waitKind) []WaitEdge {
+		for _, blocker := range blockers(waiting, id, kind) {
+			edge := WaitEdge{Owner: waiting, LockID: id, Blocker: blocker}
+			if blocker == owner {
+				return []WaitEdge{edge}
+			}
+			if visited[blocker] {
+				continue
+			}
+			visited[blocker] = true
+			for w := range owners2Waiters[blocker] {
+				if w.cancelled || (w.kind != waitLock && w.kind != waitRead) {
+					continue
+				}
+				if cycle := visit(blocker, w.id, w.kind); cycle != nil {
+					return append([]WaitEdge{edge}, cycle...)
+				}
+			}
+		}
+
+		return nil
+	}
+
+	if cycle := visit(owner, id, kind); cycle != nil {
+		return &DeadlockError{Cycle: cycle}
+	}
+
+	return nil
+}
+
+// blockers returns owners the wait for the lock id depends on. mutexLocker must be locked.
+// Writer waits for the holder, the writer that waits for readers to leave and the readers.
+// Reader also waits for all queued writers, because writers have preference.
+func blockers(waiting, id string, kind waitKind) []string {
+	result := []string{}
+	if m, ok := lockers[id]; ok {
+		if m.holder != "" {
+			result = append(result, m.holder)
+		}
+		if m.draining != "" && m.draining != waiting {
+			result = append(result, m.draining)
+		}
+	}
+
+	switch kind {
+	case waitLock:
+		for owner := range readers[id] {
+			result = append(result, owner)
+		}
+	case waitRead:
+		for owner, ws := range owners2Waiters {
+			for w := range ws {
+				if w.id == id && w.kind == waitLock && !w.cancelled && owner != waiting {
+					result = append(result, owner)
+					break
+				}
+			}
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,80 @@
+package sync
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+)
+
+func TestDeadlock(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("#detectDeadlock", func() {
+		g.It("should reject wait that closes a cycle", func(done Done) {
+			ownerA, ownerB := "12124", "12125"
+			lockX, lockY := "12142", "12143"
+			Lock(ownerA, lockX)
+			Lock(ownerB, lockY)
+			go func() {
+				g.Assert(Lock(ownerA, lockY)).Equal(ErrLockCancelled)
+				done()
+			}()
+			time.Sleep(time.Millisecond * 20)
+
+			err := Lock(ownerB, lockX)
+			g.Assert(err == nil).IsFalse()
+			deadlock, ok := err.(*DeadlockError)
+			g.Assert(ok).IsTrue()
+			g.Assert(deadlock.Cycle).Equal([]WaitEdge{
+				{Owner: ownerB, LockID: lockX, Blocker: ownerA},
+				{Owner: ownerA, LockID: lockY, Blocker: ownerB},
+			})
+			g.Assert(owners2Waiters[ownerB] == nil).IsTrue()
+			g.Assert(len(owners2Lockers[ownerB])).Equal(1)
+
+			UnlockForOwner(ownerA)
+			Unlock(ownerB, lockY)
+		})
+		g.It("should reject relocking by the holder", func() {
+			lockID := "12242"
+			Lock("12224", lockID)
+			_, ok := LockWithTimeout("12224", lockID, time.Second).(*DeadlockError)
+			g.Assert(ok).IsTrue()
+			Unlock("12224", lockID)
+			g.Assert(lockers[lockID] == nil).IsTrue()
+		})
+		g.It("should reject reader waiting for writer that waits for it", func(done Done) {
+			ownerA, ownerB := "12324", "12325"
+			lockX, lockY := "12342", "12343"
+			RLock(ownerA, lockX)
+			Lock(ownerB, lockY)
+			go func() {
+				Lock(ownerB, lockX)
+				Unlock(ownerB, lockX)
+				done()
+			}()
+			time.Sleep(time.Millisecond * 20)
+
+			_, ok := RLock(ownerA, lockY).(*DeadlockError)
+			g.Assert(ok).IsTrue()
+			Unlock(ownerB, lockY)
+			RUnlock(ownerA, lockX)
+		})
+		g.It("should not reject independent waits", func(done Done) {
+			lockID := "12442"
+			Lock("12424", lockID)
+			go func() {
+				g.Assert(Lock("12425", lockID)).Equal(nil)
+				Unlock("12425", lockID)
+				done()
+			}()
+			go func() {
+				g.Assert(Lock("12426", lockID)).Equal(nil)
+				Unlock("12426", lockID)
+			}()
+			time.Sleep(time.Millisecond * 20)
+			Unlock("12424", lockID)
+		})
+	})
+}
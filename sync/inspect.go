@@ -0,0 +1,125 @@
+package sync
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrNotLocked returns when lock is not held by anyone
+var ErrNotLocked = errors.New("lock is not held")
+
+// LockInfo describes state of the lock. Owners are WAMP connection IDs.
+type LockInfo struct {
+	ID         string         `json:"id"`
+	Holder     string         `json:"holder,omitempty"`
+	AcquiredAt *time.Time     `json:"acquiredAt,omitempty"`
+	Token      int            `json:"token,omitempty"`
+	Leased     bool           `json:"leased,omitempty"`
+	Readers    map[string]int `json:"readers,omitempty"`
+	Waiters    []string       `json:"waiters"`
+}
+
+// Owners returns all owners holding or waiting for the lock
+func (l LockInfo) Owners() []string {
+	owners := []string{}
+	if l.Holder != "" {
+		owners = append(owners, l.Holder)
+	}
+	for owner := range l.Readers {
+		owners = append(owners, owner)
+	}
+
+	return append(owners, l.Waiters...)
+}
+
+// Inspect returns state of all locks sorted by ID
+func Inspect() []LockInfo {
+	mutexLocker.Lock()
+	defer mutexLocker.Unlock()
+
+	infos := map[string]*LockInfo{}
+	info := func(id string) *LockInfo {
+		l, ok := infos[id]
+		if !ok {
+			l = &LockInfo{ID: id, Waiters: []string{}}
+			infos[id] = l
+		}
+		return l
+	}
+
+	for id, m := range lockers {
+		l := info(id)
+		if m.holder != "" {
+			acquiredAt := m.acquiredAt
+			l.Holder = m.holder
+			l.AcquiredAt = &acquiredAt
+			l.Token = m.token
+			l.Leased = m.lease != nil
+		}
+	}
+	for id, owners := range readers {
+		l := info(id)
+		l.Readers = map[string]int{}
+		for owner, n := range owners {
+			l.Readers[owner] = n
+		}
+	}
+	for owner, ws := range owners2Waiters {
+		for w := range ws {
+			if (w.kind == waitLock || w.kind == waitRead) && !w.cancelled {
+				l := info(w.id)
+				l.Waiters = append(l.Waiters, owner)
+			}
+		}
+	}
+
+	result := make([]LockInfo, 0, len(infos))
+	for _, l := range infos {
+		sort.Strings(l.Waiters)
+		result = append(result, *l)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+
+	return result
+}
+
+// ForceRelease releases lock held by any owner, including all its read locks. Waiters are not affected.
+// Release is written to the audit log with the initiator and reason provided.
+func ForceRelease(id, by, reason string) error {
+	mutexLocker.Lock()
+	defer mutexLocker.Unlock()
+
+	auditLog := log.WithFields(log.Fields{
+		"audit":  "sync",
+		"lockId": id,
+		"by":     by,
+		"reason": reason,
+	})
+
+	m := lockers[id]
+	if (m == nil || m.holder == "") && len(readers[id]) == 0 {
+		auditLog.Warn("Lock force release rejected: lock is not held")
+		return ErrNotLocked
+	}
+
+	if m != nil && m.holder != "" {
+		auditLog.WithFields(log.Fields{"holder": m.holder, "acquiredAt": m.acquiredAt, "token": m.token}).Warn("Lock force released")
+		release(m.holder, id, m)
+	}
+
+	rs := map[string]int{}
+	for owner, n := range readers[id] {
+		rs[owner] = n
+	}
+	for owner, n := range rs {
+		auditLog.WithFields(log.Fields{"reader": owner, "count": n}).Warn("Read lock force released")
+		for i := 0; i < n; i++ {
+			rrelease(owner, id)
+		}
+	}
+
+	return nil
+}
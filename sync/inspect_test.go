@@ -0,0 +1,78 @@
+package sync
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+)
+
+func TestInspect(t *testing.T) {
+	g := Goblin(t)
+
+	find := func(id string) *LockInfo {
+		for _, l := range Inspect() {
+			if l.ID == id {
+				return &l
+			}
+		}
+		return nil
+	}
+
+	g.Describe("#Inspect", func() {
+		g.It("should list holder, readers and waiters of the lock", func(done Done) {
+			lockID := "11142"
+			Lock("11124", lockID)
+			go func() {
+				Lock("11125", lockID)
+				Unlock("11125", lockID)
+				done()
+			}()
+			time.Sleep(time.Millisecond * 20)
+
+			l := find(lockID)
+			g.Assert(l == nil).IsFalse()
+			g.Assert(l.Holder).Equal("11124")
+			g.Assert(l.AcquiredAt == nil).IsFalse()
+			g.Assert(l.Waiters).Equal([]string{"11125"})
+			g.Assert(len(l.Owners())).Equal(2)
+			Unlock("11124", lockID)
+		})
+		g.It("should list readers", func() {
+			lockID := "11242"
+			RLock("11224", lockID)
+			l := find(lockID)
+			g.Assert(l.Holder).Equal("")
+			g.Assert(l.Readers["11224"]).Equal(1)
+			RUnlock("11224", lockID)
+			g.Assert(find(lockID) == nil).IsTrue()
+		})
+	})
+
+	g.Describe("#ForceRelease", func() {
+		g.It("should release lock of any owner and pass it to the next waiter", func(done Done) {
+			lockID := "11342"
+			Lock("11324", lockID)
+			go func() {
+				g.Assert(Lock("11325", lockID)).Equal(nil)
+				Unlock("11325", lockID)
+				done()
+			}()
+			time.Sleep(time.Millisecond * 20)
+			g.Assert(ForceRelease(lockID, "admin", "hung worker")).Equal(nil)
+			_, err := FencingToken("11324", lockID)
+			g.Assert(err).Equal(ErrNotHolder)
+		})
+		g.It("should release read locks", func() {
+			lockID := "11442"
+			RLock("11424", lockID)
+			RLock("11424", lockID)
+			g.Assert(ForceRelease(lockID, "admin", "")).Equal(nil)
+			g.Assert(readers[lockID] == nil).IsTrue()
+			g.Assert(owners2Readers["11424"] == nil).IsTrue()
+		})
+		g.It("should return error if lock is not held", func() {
+			g.Assert(ForceRelease("11542", "admin", "")).Equal(ErrNotLocked)
+		})
+	})
+}
@@ -0,0 +1,126 @@
+package sync
+
+import (
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	latches = map[string]*latch{}
+
+	// ErrInvalidCount returns when latch count is not positive
+	ErrInvalidCount = errors.New("invalid latch count")
+	// ErrLatchExists returns when latch with the same name is created and not opened yet
+	ErrLatchExists = errors.New("latch already exists")
+	// ErrLatchNotFound returns when latch is not created
+	ErrLatchNotFound = errors.New("latch not found")
+)
+
+type latch struct {
+	count    int
+	created  bool
+	awaiting int
+	arrived  map[string]struct{} // owners that counted down
+	done     chan struct{}
+}
+
+// CreateLatch creates latch with provided name that opens after count owners call CountDown.
+// Owners may await latch before it is created. Opened latch stays open until it is created again.
+func CreateLatch(name string, count int) error {
+	if count <= 0 {
+		return ErrInvalidCount
+	}
+
+	mutexLocker.Lock()
+	defer mutexLocker.Unlock()
+	l, ok := latches[name]
+	if ok && l.created && l.count > 0 {
+		return ErrLatchExists
+	}
+	if !ok || l.created {
+		l = &latch{done: make(chan struct{})}
+		latches[name] = l
+	}
+	l.created = true
+	l.count = count
+	l.arrived = map[string]struct{}{}
+	log.Debugf("latch id: %s CREATED with count %d", name, count)
+
+	return nil
+}
+
+// CountDown decrements count of the latch. Latch opens when count reaches zero.
+// Every owner counts down only once, repeated calls are ignored.
+func CountDown(owner, name string) error {
+	mutexLocker.Lock()
+	defer mutexLocker.Unlock()
+	l, ok := latches[name]
+	if !ok || !l.created {
+		return ErrLatchNotFound
+	}
+	if _, ok := l.arrived[owner]; ok || l.count == 0 {
+		return nil
+	}
+
+	l.arrived[owner] = struct{}{}
+	l.count--
+	log.Debugf("latch id: %s COUNTED DOWN by owner %s, %d left", name, owner, l.count)
+	if l.count == 0 {
+		close(l.done)
+		log.Debugf("latch id: %s OPENED", name)
+	}
+
+	return nil
+}
+
+// AwaitLatch waits until latch with provided name opens. Latch may be created after await started.
+// Zero timeout means no timeout. Returns ErrLockTimeout if timeout reached or ErrLockCancelled if owner was disconnected.
+func AwaitLatch(owner, name string, timeout time.Duration) error {
+	mutexLocker.Lock()
+	defer mutexLocker.Unlock()
+	l, ok := latches[name]
+	if !ok {
+		l = &latch{done: make(chan struct{})}
+		latches[name] = l
+	}
+	if l.created && l.count == 0 {
+		return nil
+	}
+
+	w := addWaiter(owner, name, waitLatch)
+	defer removeWaiter(owner, w)
+	var timer <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+
+	l.awaiting++
+	mutexLocker.Unlock()
+	select {
+	case <-l.done:
+	case <-w.cancel:
+	case <-timer:
+	}
+	mutexLocker.Lock()
+	l.awaiting--
+
+	select {
+	case <-l.done:
+		return nil
+	default:
+	}
+
+	if !l.created && l.awaiting == 0 && latches[name] == l {
+		delete(latches, name)
+	}
+	if w.cancelled {
+		log.Debugf("latch id: %s await CANCELLED for owner %s", name, owner)
+		return ErrLockCancelled
+	}
+
+	return ErrLockTimeout
+}
@@ -0,0 +1,60 @@
+package sync
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+)
+
+func TestLatch(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("#AwaitLatch", func() {
+		g.It("should wait until latch counted down to zero", func(done Done) {
+			name := "15142"
+			g.Assert(CreateLatch(name, 2)).Equal(nil)
+			g.Assert(CreateLatch(name, 2)).Equal(ErrLatchExists)
+			go func() {
+				g.Assert(AwaitLatch("15124", name, 0)).Equal(nil)
+				done()
+			}()
+			time.Sleep(time.Millisecond * 20)
+			g.Assert(CountDown("15125", name)).Equal(nil)
+			g.Assert(AwaitLatch("15126", name, time.Millisecond*10)).Equal(ErrLockTimeout)
+			g.Assert(CountDown("15125", name)).Equal(nil)
+			g.Assert(AwaitLatch("15126", name, time.Millisecond*10)).Equal(ErrLockTimeout)
+			g.Assert(CountDown("15127", name)).Equal(nil)
+		})
+		g.It("should not block await of opened latch", func() {
+			name := "15442"
+			CreateLatch(name, 1)
+			g.Assert(CountDown("15424", name)).Equal(nil)
+			g.Assert(AwaitLatch("15425", name, time.Millisecond*50)).Equal(nil)
+			g.Assert(AwaitLatch("15426", name, 0)).Equal(nil)
+			g.Assert(CreateLatch(name, 1)).Equal(nil)
+			g.Assert(AwaitLatch("15425", name, time.Millisecond*10)).Equal(ErrLockTimeout)
+		})
+		g.It("should wait for latch created later", func(done Done) {
+			name := "15242"
+			g.Assert(CountDown("15224", name)).Equal(ErrLatchNotFound)
+			go func() {
+				g.Assert(AwaitLatch("15225", name, time.Second)).Equal(nil)
+				done()
+			}()
+			time.Sleep(time.Millisecond * 20)
+			CreateLatch(name, 1)
+			CountDown("15224", name)
+		})
+		g.It("should cancel await of disconnected owner", func(done Done) {
+			name := "15342"
+			go func() {
+				g.Assert(AwaitLatch("15324", name, 0)).Equal(ErrLockCancelled)
+				g.Assert(latches[name] == nil).IsTrue()
+				done()
+			}()
+			time.Sleep(time.Millisecond * 20)
+			UnlockForOwner("15324")
+		})
+	})
+}
@@ -1,9 +1,13 @@
 package sync
 
 import (
+	"errors"
 	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
+
+	"github.com/getblank/blank-sr/bdb"
 )
 
 var (
@@ -11,16 +15,130 @@ var (
 	mutexLocker     sync.Mutex
 	owners2Lockers  = map[string][]string{}
 	lockersCounters = map[string]int{}
+	owners2Waiters  = map[string]map[*waiter]struct{}{}
+
+	leaseExpiredHandlers = []func(owner, id string){}
+
+	fencingBucket = "__fencingTokens"
+	issuedTokens  = map[string]int{} // last fencing token handed out for lock id
+	db            = bdb.DB{}
+
+	// ErrLockTimeout returns when lock was not acquired in time
+	ErrLockTimeout = errors.New("lock wait timeout")
+	// ErrLockCancelled returns when owner was disconnected while waiting for the lock
+	ErrLockCancelled = errors.New("lock wait cancelled")
+	// ErrStaleToken returns when fencing token is older than the last issued one
+	ErrStaleToken = errors.New("stale fencing token")
+	// ErrNotHolder returns when owner does not hold the lock
+	ErrNotHolder = errors.New("lock is not held by owner")
+
+	// errReadLocked returns when lock can't be taken by TryLock because of readers
+	errReadLocked = errors.New("lock is held by readers")
 )
 
 type locker struct {
-	ch chan struct{}
+	ch         chan struct{}
+	holder     string
+	draining   string // owner that took the locker and waits for readers to leave
+	acquiredAt time.Time
+	token      int
+	lease      *lease
 }
 
-// Lock create new locker for provided id if it is not exists or takes existing, then locks it
-func Lock(owner, id string) {
+type waiter struct {
+	id        string
+	kind      waitKind
+	cancel    chan struct{}
+	cancelled bool
+}
+
+type waitKind int
+
+const (
+	waitLock waitKind = iota
+	waitRead
+	waitSemaphore
+	waitBarrier
+	waitLatch
+	waitOnce
+)
+
+type lease struct {
+	timer *time.Timer
+}
+
+// Lock create new locker for provided id if it is not exists or takes existing, then locks it.
+// Returns ErrLockCancelled if owner was disconnected while waiting
+// or DeadlockError if the wait would never end because of the cycle of waits.
+func Lock(owner, id string) error {
 	log.Debugf("mutex.Lock id: %s REQUEST for owner %s", id, owner)
+	m, w, err := enqueue(owner, id, true)
+	if err != nil {
+		return err
+	}
+	if !m.lockOrCancel(w.cancel, nil) {
+		return dequeue(owner, id, w, nil)
+	}
+
+	if err := acquired(owner, id, m, w, nil, false); err != nil {
+		return err
+	}
+	log.Debugf("mutex.Lock id: %s LOCKED for owner %s", id, owner)
+	return nil
+}
+
+// LockWithTimeout works like Lock, but waits for the locker no longer than timeout.
+// If timeout reached, caller is removed from the waiters queue and ErrLockTimeout returned.
+func LockWithTimeout(owner, id string, timeout time.Duration) error {
+	log.Debugf("mutex.LockWithTimeout id: %s REQUEST for owner %s, timeout %v", id, owner, timeout)
+	m, w, err := enqueue(owner, id, true)
+	if err != nil {
+		return err
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	if !m.lockOrCancel(w.cancel, timer.C) {
+		log.Debugf("mutex.LockWithTimeout id: %s TIMEOUT for owner %s", id, owner)
+		return dequeue(owner, id, w, ErrLockTimeout)
+	}
+
+	if err := acquired(owner, id, m, w, timer.C, false); err != nil {
+		return err
+	}
+	log.Debugf("mutex.LockWithTimeout id: %s LOCKED for owner %s", id, owner)
+	return nil
+}
+
+// TryLock locks locker for provided id only if it is not locked. Never blocks.
+// Returns true if locker was locked.
+func TryLock(owner, id string) bool {
+	log.Debugf("mutex.TryLock id: %s REQUEST for owner %s", id, owner)
+	m, w, _ := enqueue(owner, id, false)
+	if !m.tryLock() {
+		dequeue(owner, id, w, nil)
+		log.Debugf("mutex.TryLock id: %s BUSY for owner %s", id, owner)
+		return false
+	}
+
+	if err := acquired(owner, id, m, w, nil, true); err != nil {
+		return false
+	}
+	log.Debugf("mutex.TryLock id: %s LOCKED for owner %s", id, owner)
+	return true
+}
+
+// enqueue takes locker for provided id and registers owner in it as a waiter.
+// If detect is true, returns DeadlockError instead if the wait would close a cycle.
+func enqueue(owner, id string, detect bool) (*locker, *waiter, error) {
 	mutexLocker.Lock()
+	defer mutexLocker.Unlock()
+	if detect {
+		if err := detectDeadlock(owner, id, waitLock); err != nil {
+			log.Warnf("mutex id: %s REJECTED for owner %s: %v", id, owner, err)
+			return nil, nil, err
+		}
+	}
+
 	m, ok := lockers[id]
 	if !ok {
 		m = new(locker)
@@ -32,10 +150,138 @@ func Lock(owner, id string) {
 	}
 	owners2Lockers[owner] = append(owners2Lockers[owner], id)
 	lockersCounters[id]++
+
+	return m, addWaiter(owner, id, waitLock), nil
+}
+
+// addWaiter registers wait of the owner, so it can be cancelled by UnlockForOwner. mutexLocker must be locked.
+func addWaiter(owner, id string, kind waitKind) *waiter {
+	w := &waiter{id: id, kind: kind, cancel: make(chan struct{})}
+	if _, ok := owners2Waiters[owner]; !ok {
+		owners2Waiters[owner] = map[*waiter]struct{}{}
+	}
+	owners2Waiters[owner][w] = struct{}{}
+
+	return w
+}
+
+// acquired waits until all readers of the locker leave, then marks owner as the holder of the locker.
+// New readers are not admitted meanwhile, so writers are not starved.
+// If waiter was cancelled concurrently, locker is released and ErrLockCancelled returned.
+// If try is true, locker is released and errReadLocked returned instead of waiting for readers.
+func acquired(owner, id string, m *locker, w *waiter, timeout <-chan time.Time, try bool) error {
+	mutexLocker.Lock()
+	defer mutexLocker.Unlock()
+	m.draining = owner
+	defer func() {
+		m.draining = ""
+	}()
+	for len(readers[id]) > 0 && !w.cancelled {
+		if try {
+			removeWaiter(owner, w)
+			release(owner, id, m)
+			return errReadLocked
+		}
+
+		changed := rwChanged(id)
+		mutexLocker.Unlock()
+		var timedOut bool
+		select {
+		case <-changed:
+		case <-w.cancel:
+		case <-timeout:
+			timedOut = true
+		}
+		mutexLocker.Lock()
+		if timedOut && !w.cancelled {
+			removeWaiter(owner, w)
+			release(owner, id, m)
+			return ErrLockTimeout
+		}
+	}
+
+	removeWaiter(owner, w)
+	acquiredAt := time.Now()
+	m.holder = owner
+	m.acquiredAt = acquiredAt
+	m.token = 0
+	if w.cancelled {
+		log.Debugf("mutex id: %s acquired by cancelled waiter %s, releasing", id, owner)
+		release(owner, id, m)
+		return ErrLockCancelled
+	}
+
+	// DB write is slow, so token is taken without the global lock
+	m.draining = ""
 	mutexLocker.Unlock()
+	token, err := db.NextSequenceForKey(fencingBucket, id)
+	mutexLocker.Lock()
+	if m.holder != owner || !m.acquiredAt.Equal(acquiredAt) {
+		log.Debugf("mutex id: %s released while taking fencing token for owner %s", id, owner)
+		return ErrLockCancelled
+	}
+	if err != nil {
+		log.WithError(err).Errorf("Can't get fencing token for lock %s", id)
+		release(owner, id, m)
+		return err
+	}
+	m.token = token
+	issuedTokens[id] = token
 
-	m.lock()
-	log.Debugf("mutex.Lock id: %s LOCKED for owner %s", id, owner)
+	return nil
+}
+
+// dequeue removes owner that did not get the locker. It is the reverse of enqueue.
+// Returns ErrLockCancelled if waiter was cancelled or err otherwise.
+func dequeue(owner, id string, w *waiter, err error) error {
+	mutexLocker.Lock()
+	defer mutexLocker.Unlock()
+	removeWaiter(owner, w)
+	forget(owner, id)
+	if w.cancelled {
+		log.Debugf("mutex id: %s wait CANCELLED for owner %s", id, owner)
+		return ErrLockCancelled
+	}
+
+	return err
+}
+
+// removeWaiter removes waiter registered by enqueue. mutexLocker must be locked.
+func removeWaiter(owner string, w *waiter) {
+	delete(owners2Waiters[owner], w)
+	if len(owners2Waiters[owner]) == 0 {
+		delete(owners2Waiters, owner)
+	}
+}
+
+// cancelWaiters cancels all waits of the owner. mutexLocker must be locked.
+func cancelWaiters(owner string) {
+	for w := range owners2Waiters[owner] {
+		if !w.cancelled {
+			w.cancelled = true
+			close(w.cancel)
+		}
+	}
+}
+
+// forget removes owner's registration in locker made by enqueue. mutexLocker must be locked.
+func forget(owner, id string) {
+	lockersCounters[id]--
+	if lockersCounters[id] == 0 {
+		delete(lockers, id)
+		delete(lockersCounters, id)
+		rwNotify(id)
+	}
+	ids := owners2Lockers[owner]
+	for i := len(ids) - 1; i >= 0; i-- {
+		if ids[i] == id {
+			owners2Lockers[owner] = append(ids[:i], ids[i+1:]...)
+			break
+		}
+	}
+	if len(owners2Lockers[owner]) == 0 {
+		delete(owners2Lockers, owner)
+	}
 }
 
 // Unlock takes existing locker from map and unlocks it
@@ -48,41 +294,146 @@ func Unlock(owner, id string) {
 		log.Debugf("mutex.Unlock id: %s NOT FOUND for owner %s", id, owner)
 		return
 	}
-	m.unlock()
-	lockersCounters[id]--
-	if lockersCounters[id] == 0 {
-		delete(lockers, id)
-		delete(lockersCounters, id)
-	}
-	for i := len(owners2Lockers[owner]) - 1; i >= 0; i-- {
-		_id := owners2Lockers[owner][i]
-		if id == _id {
-			owners2Lockers[owner] = append(owners2Lockers[owner][:i], owners2Lockers[owner][i+1:]...)
-		}
-		if len(owners2Lockers[owner]) == 0 {
-			delete(owners2Lockers, owner)
-		}
+	if m.holder != owner {
+		log.Warnf("mutex.Unlock id: %s is not held by owner %s", id, owner)
+		return
 	}
+	release(owner, id, m)
 	log.Debugf("mutex.Unlock id: %s UNLOCKED for owner %s", id, owner)
 }
 
-// UnlockForOwner unlocks all lockers locked by owner
+// UnlockForOwner unlocks all lockers locked by owner, including read locks and semaphore permits, and cancels all its waits.
+// Work the owner runs after Do is abandoned.
 func UnlockForOwner(owner string) {
 	log.Debugf("mutex.UnlockForOwner REQUEST for owner %s", owner)
+	// waits are cancelled first, so cancelled waiter can't start the work after it is abandoned
+	defer abandonOncers(owner)
 	mutexLocker.Lock()
 	defer mutexLocker.Unlock()
-	if locks, ok := owners2Lockers[owner]; ok {
-		for _, id := range locks {
-			lockers[id].unlock()
-			lockersCounters[id]--
+	cancelWaiters(owner)
+	locks := append([]string{}, owners2Lockers[owner]...)
+	for _, id := range locks {
+		if m := lockers[id]; m != nil && m.holder == owner {
+			release(owner, id, m)
 		}
-		delete(owners2Lockers, owner)
 	}
+	rlocks := append([]string{}, owners2Readers[owner]...)
+	for _, id := range rlocks {
+		rrelease(owner, id)
+	}
+	releaseSemaphoresForOwner(owner)
 	log.Debugf("mutex.UnlockForOwner UNLOCKED ALL for owner %s", owner)
 }
 
-func (l *locker) lock() {
-	l.ch <- struct{}{}
+// Renew sets lease of the lock held by owner. Lock will be released automatically
+// if it is not renewed or unlocked before lease expires.
+func Renew(owner, id string, d time.Duration) error {
+	mutexLocker.Lock()
+	defer mutexLocker.Unlock()
+	m, ok := lockers[id]
+	if !ok || m.holder != owner {
+		return ErrNotHolder
+	}
+
+	m.stopLease()
+	l := new(lease)
+	l.timer = time.AfterFunc(d, func() {
+		expireLease(owner, id, m, l)
+	})
+	m.lease = l
+	log.Debugf("mutex.Renew id: %s LEASED for owner %s for %v", id, owner, d)
+
+	return nil
+}
+
+// FencingToken returns fencing token of the lock held by owner.
+// Every acquisition of the lock gets token greater than all issued before, even across restarts.
+func FencingToken(owner, id string) (int, error) {
+	mutexLocker.Lock()
+	defer mutexLocker.Unlock()
+	m, ok := lockers[id]
+	if !ok || m.holder != owner {
+		return 0, ErrNotHolder
+	}
+
+	return m.token, nil
+}
+
+// Validate returns ErrStaleToken if token is older than the last one issued for the lock.
+// Storage services use it to reject writes of the owners that lost the lock.
+func Validate(id string, token int) error {
+	mutexLocker.Lock()
+	current, ok := issuedTokens[id]
+	mutexLocker.Unlock()
+	if !ok {
+		// nothing issued since start, tokens of the previous run are compared with the DB sequence
+		var err error
+		current, err = db.GetSequenceForKey(fencingBucket, id)
+		if err != nil {
+			return err
+		}
+	}
+	if token < current {
+		return ErrStaleToken
+	}
+
+	return nil
+}
+
+// OnLeaseExpired registers callback that will called when lock released because its lease expired
+func OnLeaseExpired(fn func(owner, id string)) {
+	leaseExpiredHandlers = append(leaseExpiredHandlers, fn)
+}
+
+func expireLease(owner, id string, m *locker, l *lease) {
+	mutexLocker.Lock()
+	defer mutexLocker.Unlock()
+	if lockers[id] != m || m.holder != owner || m.lease != l {
+		return
+	}
+
+	log.Warnf("mutex lease expired, id: %s RELEASED for owner %s, held since %v", id, owner, m.acquiredAt)
+	release(owner, id, m)
+	for _, h := range leaseExpiredHandlers {
+		go h(owner, id)
+	}
+}
+
+// release unlocks locker held by owner. mutexLocker must be locked.
+func release(owner, id string, m *locker) {
+	m.holder = ""
+	m.stopLease()
+	m.unlock()
+	forget(owner, id)
+}
+
+// lockOrCancel locks locker or returns false if cancel or timeout fired first.
+// nil timeout means no timeout.
+func (l *locker) lockOrCancel(cancel <-chan struct{}, timeout <-chan time.Time) bool {
+	select {
+	case l.ch <- struct{}{}:
+		return true
+	case <-cancel:
+		return false
+	case <-timeout:
+		return false
+	}
+}
+
+func (l *locker) tryLock() bool {
+	select {
+	case l.ch <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *locker) stopLease() {
+	if l.lease != nil {
+		l.lease.timer.Stop()
+		l.lease = nil
+	}
 }
 
 func (l *locker) unlock() {
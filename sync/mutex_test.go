@@ -91,4 +91,160 @@ func TestMutex(t *testing.T) {
 			UnlockForOwner(owner)
 		})
 	})
+
+	g.Describe("#TryLock", func() {
+		g.It("should lock free locker", func() {
+			owner := "3124"
+			lockID := "3142"
+			g.Assert(TryLock(owner, lockID)).IsTrue()
+			g.Assert(lockersCounters[lockID]).Equal(1)
+			Unlock(owner, lockID)
+		})
+		g.It("should not block and not enqueue when locker is busy", func() {
+			owner := "3224"
+			otherOwner := "3225"
+			lockID := "3242"
+			Lock(owner, lockID)
+			g.Assert(TryLock(otherOwner, lockID)).IsFalse()
+			g.Assert(lockersCounters[lockID]).Equal(1)
+			g.Assert(owners2Lockers[otherOwner] == nil).IsTrue()
+			Unlock(owner, lockID)
+			g.Assert(lockers[lockID] == nil).IsTrue()
+		})
+	})
+
+	g.Describe("#LockWithTimeout", func() {
+		g.It("should lock free locker", func() {
+			owner := "4124"
+			lockID := "4142"
+			g.Assert(LockWithTimeout(owner, lockID, time.Millisecond*10)).Equal(nil)
+			Unlock(owner, lockID)
+		})
+		g.It("should return error and leave the queue after timeout", func() {
+			owner := "4224"
+			otherOwner := "4225"
+			lockID := "4242"
+			Lock(owner, lockID)
+			g.Assert(LockWithTimeout(otherOwner, lockID, time.Millisecond*10)).Equal(ErrLockTimeout)
+			g.Assert(lockersCounters[lockID]).Equal(1)
+			g.Assert(owners2Lockers[otherOwner] == nil).IsTrue()
+			Unlock(owner, lockID)
+			g.Assert(lockers[lockID] == nil).IsTrue()
+			g.Assert(TryLock(otherOwner, lockID)).IsTrue()
+			Unlock(otherOwner, lockID)
+		})
+	})
+
+	g.Describe("#Renew", func() {
+		g.It("should release lock when lease expires", func(done Done) {
+			owner := "5124"
+			otherOwner := "5125"
+			lockID := "5142"
+			OnLeaseExpired(func(expiredOwner, expiredID string) {
+				if expiredID != lockID {
+					return
+				}
+				g.Assert(expiredOwner).Equal(owner)
+				done()
+			})
+			Lock(owner, lockID)
+			g.Assert(Renew(owner, lockID, time.Millisecond*20)).Equal(nil)
+			g.Assert(LockWithTimeout(otherOwner, lockID, time.Second)).Equal(nil)
+			g.Assert(owners2Lockers[owner] == nil).IsTrue()
+			Unlock(owner, lockID)
+			g.Assert(lockers[lockID].holder).Equal(otherOwner)
+			Unlock(otherOwner, lockID)
+		})
+		g.It("should extend lease", func() {
+			owner := "5224"
+			lockID := "5242"
+			Lock(owner, lockID)
+			Renew(owner, lockID, time.Millisecond*20)
+			time.Sleep(time.Millisecond * 10)
+			Renew(owner, lockID, time.Millisecond*40)
+			time.Sleep(time.Millisecond * 20)
+			g.Assert(lockers[lockID].holder).Equal(owner)
+			Unlock(owner, lockID)
+			g.Assert(lockers[lockID] == nil).IsTrue()
+		})
+		g.It("should return error if owner does not hold the lock", func() {
+			owner := "5324"
+			lockID := "5342"
+			g.Assert(Renew(owner, lockID, time.Second)).Equal(ErrNotHolder)
+			Lock(owner, lockID)
+			g.Assert(Renew("5325", lockID, time.Second)).Equal(ErrNotHolder)
+			Unlock(owner, lockID)
+		})
+	})
+
+	g.Describe("#UnlockForOwner waiters", func() {
+		g.It("should cancel waits of disconnected owner so lock is not acquired for it", func(done Done) {
+			owner := "6124"
+			disconnectedOwner := "6125"
+			lockID := "6142"
+			Lock(owner, lockID)
+			go func() {
+				g.Assert(Lock(disconnectedOwner, lockID)).Equal(ErrLockCancelled)
+				mutexLocker.Lock()
+				_, waiting := owners2Waiters[disconnectedOwner]
+				_, locking := owners2Lockers[disconnectedOwner]
+				mutexLocker.Unlock()
+				g.Assert(waiting).IsFalse()
+				g.Assert(locking).IsFalse()
+
+				Unlock(owner, lockID)
+				g.Assert(TryLock("6126", lockID)).IsTrue()
+				Unlock("6126", lockID)
+				g.Assert(lockers[lockID] == nil).IsTrue()
+				done()
+			}()
+			time.Sleep(time.Millisecond * 20)
+			UnlockForOwner(disconnectedOwner)
+		})
+		g.It("should release lock acquired by cancelled waiter", func() {
+			owner := "6224"
+			lockID := "6242"
+			m, w, _ := enqueue(owner, lockID, false)
+			m.tryLock()
+			mutexLocker.Lock()
+			cancelWaiters(owner)
+			mutexLocker.Unlock()
+			g.Assert(acquired(owner, lockID, m, w, nil, false)).Equal(ErrLockCancelled)
+			g.Assert(lockers[lockID] == nil).IsTrue()
+			g.Assert(owners2Lockers[owner] == nil).IsTrue()
+		})
+	})
+
+	g.Describe("#FencingToken", func() {
+		g.It("should increase token on every acquisition", func() {
+			owner := "7124"
+			lockID := "7142"
+			Lock(owner, lockID)
+			first, err := FencingToken(owner, lockID)
+			g.Assert(err == nil).IsTrue()
+			Unlock(owner, lockID)
+			Lock(owner, lockID)
+			second, _ := FencingToken(owner, lockID)
+			Unlock(owner, lockID)
+			g.Assert(second > first).IsTrue()
+		})
+		g.It("should return error if owner does not hold the lock", func() {
+			_, err := FencingToken("7224", "7242")
+			g.Assert(err).Equal(ErrNotHolder)
+		})
+	})
+
+	g.Describe("#Validate", func() {
+		g.It("should reject tokens older than the last issued", func() {
+			lockID := "8142"
+			Lock("8124", lockID)
+			stale, _ := FencingToken("8124", lockID)
+			Unlock("8124", lockID)
+			Lock("8125", lockID)
+			current, _ := FencingToken("8125", lockID)
+			Unlock("8125", lockID)
+			g.Assert(Validate(lockID, stale)).Equal(ErrStaleToken)
+			g.Assert(Validate(lockID, current)).Equal(nil)
+		})
+	})
 }
@@ -4,36 +4,216 @@ import (
 	"errors"
 	"sync"
 	"time"
+
+	log "github.com/sirupsen/logrus"
 )
 
 var (
-	oncers      = map[string]struct{}{}
-	onceLocker  sync.Mutex
-	errExecuted = errors.New("already executed")
-	ttl         = time.Minute
+	oncers        = map[string]*oncer{}
+	owners2Oncers = map[string]map[string]struct{}{}
+	onceLocker    sync.Mutex
+	errExecuted   = errors.New("already executed")
+	ttl           = time.Minute
+
+	onceBucket = "__oncers"
+
+	// ErrNotRunner returns when result is posted by owner that does not run the work for id
+	ErrNotRunner = errors.New("work is not run by owner")
 )
 
+// oncer is the record of executed id. Only finished records are persisted.
+type oncer struct {
+	ExpiresAt time.Time   `json:"expiresAt"`
+	Done      bool        `json:"done"`
+	Result    interface{} `json:"result,omitempty"`
+	owner     string
+	persist   bool
+	ready     chan struct{}
+	timer     *time.Timer
+}
+
 // Once will return nil only to one caller for id provided in minute.
 // Other callers will get error errExecuted
 func Once(id string) error {
-	return once(id)
+	return OnceWithTTL(id, ttl, false)
 }
 
-func once(id string) error {
+// OnceWithTTL works like Once, but remembers id for d.
+// If persist is true, id is stored in DB and remembered across restarts.
+func OnceWithTTL(id string, d time.Duration, persist bool) error {
 	onceLocker.Lock()
 	defer onceLocker.Unlock()
-	if _, ok := oncers[id]; ok {
+	if o := getOncer(id, persist); o != nil {
 		return errExecuted
 	}
-	oncers[id] = makeOncer(id)
+	setOncer(id, &oncer{Done: true}, d, persist)
+
 	return nil
 }
 
-func makeOncer(id string) struct{} {
-	time.AfterFunc(ttl, func() {
-		onceLocker.Lock()
-		delete(oncers, id)
+// Do returns run true only to one caller for id provided during d, that caller must run the work
+// and post its result with Complete. Concurrent callers wait for the result, later callers get it immediately.
+// If the runner is disconnected or d expires before result posted, one of the waiting callers becomes the runner.
+// If persist is true, result is stored in DB and returned across restarts.
+// Returns ErrLockCancelled if owner was disconnected while waiting.
+func Do(owner, id string, d time.Duration, persist bool) (run bool, result interface{}, err error) {
+	onceLocker.Lock()
+	defer onceLocker.Unlock()
+	var w *waiter
+	defer func() {
+		if w != nil {
+			mutexLocker.Lock()
+			removeWaiter(owner, w)
+			mutexLocker.Unlock()
+		}
+	}()
+	for {
+		if w != nil && waitCancelled(w) {
+			log.Debugf("once.Do id: %s wait CANCELLED for owner %s", id, owner)
+			return false, nil, ErrLockCancelled
+		}
+
+		o := getOncer(id, persist)
+		if o == nil {
+			o = &oncer{owner: owner, ready: make(chan struct{})}
+			setOncer(id, o, d, persist)
+			if _, ok := owners2Oncers[owner]; !ok {
+				owners2Oncers[owner] = map[string]struct{}{}
+			}
+			owners2Oncers[owner][id] = struct{}{}
+			log.Debugf("once.Do id: %s RUN by owner %s", id, owner)
+			return true, nil, nil
+		}
+		if o.Done {
+			return false, o.Result, nil
+		}
+
+		if w == nil {
+			mutexLocker.Lock()
+			w = addWaiter(owner, id, waitOnce)
+			mutexLocker.Unlock()
+		}
+		ready := o.ready
 		onceLocker.Unlock()
+		select {
+		case <-ready:
+		case <-w.cancel:
+		}
+		onceLocker.Lock()
+	}
+}
+
+func waitCancelled(w *waiter) bool {
+	mutexLocker.Lock()
+	defer mutexLocker.Unlock()
+
+	return w.cancelled
+}
+
+// Complete posts result of the work run by owner after Do and wakes up waiting callers
+func Complete(owner, id string, result interface{}) error {
+	onceLocker.Lock()
+	defer onceLocker.Unlock()
+	o, ok := oncers[id]
+	if !ok || o.Done || o.owner != owner {
+		return ErrNotRunner
+	}
+
+	o.Done = true
+	o.Result = result
+	o.forgetOwner(id)
+	close(o.ready)
+	if o.persist {
+		if err := db.Save(onceBucket, id, o); err != nil {
+			log.WithError(err).Errorf("Can't save once result for id %s", id)
+		}
+	}
+	log.Debugf("once.Do id: %s DONE by owner %s", id, owner)
+
+	return nil
+}
+
+// abandonOncers removes ids the owner runs work for but not posted result yet, so others can run it
+func abandonOncers(owner string) {
+	onceLocker.Lock()
+	defer onceLocker.Unlock()
+	for id := range owners2Oncers[owner] {
+		if o, ok := oncers[id]; ok && o.owner == owner && !o.Done {
+			log.Debugf("once.Do id: %s ABANDONED by owner %s", id, owner)
+			removeOncer(id, o)
+		}
+	}
+	delete(owners2Oncers, owner)
+}
+
+// getOncer returns not expired oncer for id. If persist is true and oncer is not in memory, it is loaded from DB.
+// Expired persisted oncers are deleted on access. onceLocker must be locked.
+func getOncer(id string, persist bool) *oncer {
+	if o, ok := oncers[id]; ok {
+		return o
+	}
+	if !persist {
+		return nil
+	}
+
+	o := new(oncer)
+	if err := db.GetUnmarshalledIntoInterface(onceBucket, id, o); err != nil {
+		return nil
+	}
+	if !o.ExpiresAt.After(time.Now()) {
+		db.Delete(onceBucket, id)
+		return nil
+	}
+
+	o.persist = true
+	o.timer = time.AfterFunc(time.Until(o.ExpiresAt), func() {
+		expireOncer(id, o)
+	})
+	oncers[id] = o
+
+	return o
+}
+
+// setOncer remembers oncer for id for d. onceLocker must be locked.
+func setOncer(id string, o *oncer, d time.Duration, persist bool) {
+	o.ExpiresAt = time.Now().Add(d)
+	o.persist = persist
+	o.timer = time.AfterFunc(d, func() {
+		expireOncer(id, o)
 	})
-	return struct{}{}
+	oncers[id] = o
+	if persist && o.Done {
+		if err := db.Save(onceBucket, id, o); err != nil {
+			log.WithError(err).Errorf("Can't save once id %s", id)
+		}
+	}
+}
+
+func expireOncer(id string, o *oncer) {
+	onceLocker.Lock()
+	defer onceLocker.Unlock()
+	if oncers[id] != o {
+		return
+	}
+	removeOncer(id, o)
+	if o.persist {
+		db.Delete(onceBucket, id)
+	}
+}
+
+// removeOncer forgets oncer for id and wakes up callers waiting for its result. onceLocker must be locked.
+func removeOncer(id string, o *oncer) {
+	o.timer.Stop()
+	delete(oncers, id)
+	if !o.Done {
+		o.forgetOwner(id)
+		close(o.ready)
+	}
+}
+
+func (o *oncer) forgetOwner(id string) {
+	delete(owners2Oncers[o.owner], id)
+	if len(owners2Oncers[o.owner]) == 0 {
+		delete(owners2Oncers, o.owner)
+	}
 }
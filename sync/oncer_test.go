@@ -28,4 +28,87 @@ func TestOnce(t *testing.T) {
 			g.Assert(Once(id) == nil).IsTrue()
 		})
 	})
+
+	g.Describe("#OnceWithTTL", func() {
+		g.It("should remember id for ttl provided", func() {
+			id := "3"
+			g.Assert(OnceWithTTL(id, time.Millisecond*20, false) == nil).IsTrue()
+			g.Assert(OnceWithTTL(id, time.Millisecond*20, false) == nil).IsFalse()
+			time.Sleep(time.Millisecond * 40)
+			g.Assert(OnceWithTTL(id, time.Millisecond*20, false) == nil).IsTrue()
+		})
+		g.It("should remember persisted id after it is removed from memory", func() {
+			id := "4"
+			g.Assert(OnceWithTTL(id, time.Minute, true) == nil).IsTrue()
+			onceLocker.Lock()
+			oncers[id].timer.Stop()
+			delete(oncers, id)
+			onceLocker.Unlock()
+			g.Assert(OnceWithTTL(id, time.Minute, true) == nil).IsFalse()
+		})
+	})
+
+	g.Describe("#Do", func() {
+		g.It("should return result of the first caller to concurrent and later callers", func(done Done) {
+			id := "5"
+			run, _, _ := Do("13124", id, time.Minute, false)
+			g.Assert(run).IsTrue()
+			go func() {
+				run, result, _ := Do("13125", id, time.Minute, false)
+				g.Assert(run).IsFalse()
+				g.Assert(result).Equal("result")
+				done()
+			}()
+			time.Sleep(time.Millisecond * 20)
+			g.Assert(Complete("13125", id, "wrong")).Equal(ErrNotRunner)
+			g.Assert(Complete("13124", id, "result")).Equal(nil)
+			run, result, _ := Do("13126", id, time.Minute, false)
+			g.Assert(run).IsFalse()
+			g.Assert(result).Equal("result")
+		})
+		g.It("should pass the work to waiting caller if runner disconnected", func(done Done) {
+			id := "6"
+			Do("13224", id, time.Minute, false)
+			go func() {
+				run, _, _ := Do("13225", id, time.Minute, false)
+				g.Assert(run).IsTrue()
+				g.Assert(Complete("13225", id, 1)).Equal(nil)
+				done()
+			}()
+			time.Sleep(time.Millisecond * 20)
+			UnlockForOwner("13224")
+		})
+		g.It("should cancel wait of disconnected owner", func(done Done) {
+			id := "8"
+			Do("13424", id, time.Minute, false)
+			go func() {
+				_, _, err := Do("13425", id, time.Minute, false)
+				g.Assert(err).Equal(ErrLockCancelled)
+				mutexLocker.Lock()
+				_, waiting := owners2Waiters["13425"]
+				mutexLocker.Unlock()
+				g.Assert(waiting).IsFalse()
+
+				UnlockForOwner("13424")
+				run, _, _ := Do("13426", id, time.Minute, false)
+				g.Assert(run).IsTrue()
+				Complete("13426", id, nil)
+				done()
+			}()
+			time.Sleep(time.Millisecond * 20)
+			UnlockForOwner("13425")
+		})
+		g.It("should return persisted result after it is removed from memory", func() {
+			id := "7"
+			Do("13324", id, time.Minute, true)
+			Complete("13324", id, "persisted")
+			onceLocker.Lock()
+			oncers[id].timer.Stop()
+			delete(oncers, id)
+			onceLocker.Unlock()
+			run, result, _ := Do("13325", id, time.Minute, true)
+			g.Assert(run).IsFalse()
+			g.Assert(result).Equal("persisted")
+		})
+	})
 }
@@ -0,0 +1,107 @@
+package sync
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	readers        = map[string]map[string]int{}
+	owners2Readers = map[string][]string{}
+	rwChanges      = map[string]chan struct{}{}
+)
+
+// RLock locks locker for provided id for reading. Many owners can hold read lock at the same time,
+// while Lock of the same id waits until all readers leave.
+// Writers have preference: RLock waits while any owner holds or waits for the Lock of the id.
+// Returns ErrLockCancelled if owner was disconnected while waiting or DeadlockError if the wait would close a cycle.
+func RLock(owner, id string) error {
+	log.Debugf("mutex.RLock id: %s REQUEST for owner %s", id, owner)
+	mutexLocker.Lock()
+	defer mutexLocker.Unlock()
+	if lockersCounters[id] > 0 {
+		if err := detectDeadlock(owner, id, waitRead); err != nil {
+			log.Warnf("mutex.RLock id: %s REJECTED for owner %s: %v", id, owner, err)
+			return err
+		}
+	}
+	w := addWaiter(owner, id, waitRead)
+	defer removeWaiter(owner, w)
+	for lockersCounters[id] > 0 {
+		changed := rwChanged(id)
+		mutexLocker.Unlock()
+		select {
+		case <-changed:
+		case <-w.cancel:
+		}
+		mutexLocker.Lock()
+		if w.cancelled {
+			log.Debugf("mutex.RLock id: %s wait CANCELLED for owner %s", id, owner)
+			return ErrLockCancelled
+		}
+	}
+
+	if _, ok := readers[id]; !ok {
+		readers[id] = map[string]int{}
+	}
+	readers[id][owner]++
+	owners2Readers[owner] = append(owners2Readers[owner], id)
+	log.Debugf("mutex.RLock id: %s LOCKED for owner %s", id, owner)
+
+	return nil
+}
+
+// RUnlock releases read lock of the owner taken by RLock
+func RUnlock(owner, id string) {
+	log.Debugf("mutex.RUnlock id: %s REQUEST for owner %s", id, owner)
+	mutexLocker.Lock()
+	defer mutexLocker.Unlock()
+	if readers[id][owner] == 0 {
+		log.Warnf("mutex.RUnlock id: %s is not read locked by owner %s", id, owner)
+		return
+	}
+	rrelease(owner, id)
+	log.Debugf("mutex.RUnlock id: %s UNLOCKED for owner %s", id, owner)
+}
+
+// rrelease releases one read lock of the owner. mutexLocker must be locked.
+func rrelease(owner, id string) {
+	readers[id][owner]--
+	if readers[id][owner] == 0 {
+		delete(readers[id], owner)
+	}
+	if len(readers[id]) == 0 {
+		delete(readers, id)
+		rwNotify(id)
+	}
+
+	ids := owners2Readers[owner]
+	for i := len(ids) - 1; i >= 0; i-- {
+		if ids[i] == id {
+			owners2Readers[owner] = append(ids[:i], ids[i+1:]...)
+			break
+		}
+	}
+	if len(owners2Readers[owner]) == 0 {
+		delete(owners2Readers, owner)
+	}
+}
+
+// rwChanged returns channel that will be closed when the last reader or the last writer of the id leaves.
+// mutexLocker must be locked.
+func rwChanged(id string) chan struct{} {
+	ch, ok := rwChanges[id]
+	if !ok {
+		ch = make(chan struct{})
+		rwChanges[id] = ch
+	}
+
+	return ch
+}
+
+// rwNotify wakes up all waiting for the rwChanged channel of the id. mutexLocker must be locked.
+func rwNotify(id string) {
+	if ch, ok := rwChanges[id]; ok {
+		close(ch)
+		delete(rwChanges, id)
+	}
+}
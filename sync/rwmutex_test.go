@@ -0,0 +1,94 @@
+package sync
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+)
+
+func TestRWMutex(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("#RLock", func() {
+		g.It("should allow many readers at the same time", func() {
+			lockID := "9142"
+			g.Assert(RLock("9124", lockID)).Equal(nil)
+			g.Assert(RLock("9125", lockID)).Equal(nil)
+			g.Assert(len(readers[lockID])).Equal(2)
+			RUnlock("9124", lockID)
+			RUnlock("9125", lockID)
+			g.Assert(readers[lockID] == nil).IsTrue()
+			g.Assert(owners2Readers["9124"] == nil).IsTrue()
+		})
+		g.It("should block writer until readers leave", func(done Done) {
+			lockID := "9242"
+			RLock("9224", lockID)
+			g.Assert(TryLock("9225", lockID)).IsFalse()
+			g.Assert(LockWithTimeout("9225", lockID, time.Millisecond*10)).Equal(ErrLockTimeout)
+			go func() {
+				g.Assert(Lock("9225", lockID)).Equal(nil)
+				mutexLocker.Lock()
+				g.Assert(len(readers[lockID])).Equal(0)
+				mutexLocker.Unlock()
+				Unlock("9225", lockID)
+				done()
+			}()
+			time.Sleep(time.Millisecond * 20)
+			RUnlock("9224", lockID)
+		})
+		g.It("should not admit new readers while writer waits", func(done Done) {
+			lockID := "9342"
+			RLock("9324", lockID)
+			go Lock("9325", lockID)
+			time.Sleep(time.Millisecond * 20)
+			locked := make(chan struct{})
+			go func() {
+				RLock("9326", lockID)
+				close(locked)
+			}()
+			time.Sleep(time.Millisecond * 20)
+			select {
+			case <-locked:
+				g.Fail("reader passed waiting writer")
+			default:
+			}
+			RUnlock("9324", lockID)
+			time.Sleep(time.Millisecond * 20)
+			Unlock("9325", lockID)
+			<-locked
+			RUnlock("9326", lockID)
+			done()
+		})
+	})
+
+	g.Describe("#UnlockForOwner readers", func() {
+		g.It("should release read locks and cancel read waits of the owner", func(done Done) {
+			lockID := "9442"
+			RLock("9424", lockID)
+			RLock("9424", lockID)
+			locked := make(chan struct{})
+			go func() {
+				Lock("9425", lockID)
+				close(locked)
+			}()
+			time.Sleep(time.Millisecond * 20)
+			cancelled := make(chan struct{})
+			go func() {
+				g.Assert(RLock("9426", lockID)).Equal(ErrLockCancelled)
+				close(cancelled)
+			}()
+			time.Sleep(time.Millisecond * 20)
+			UnlockForOwner("9426")
+			<-cancelled
+			UnlockForOwner("9424")
+			<-locked
+			mutexLocker.Lock()
+			g.Assert(readers[lockID] == nil).IsTrue()
+			g.Assert(owners2Readers["9424"] == nil).IsTrue()
+			mutexLocker.Unlock()
+			Unlock("9425", lockID)
+			done()
+		})
+	})
+}
@@ -0,0 +1,157 @@
+package sync
+
+import (
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	semaphores        = map[string]*semaphore{}
+	owners2Semaphores = map[string]map[string]struct{}{}
+
+	// ErrInvalidPermits returns when requested permits are not positive or greater than semaphore max
+	ErrInvalidPermits = errors.New("invalid semaphore permits")
+)
+
+type semaphore struct {
+	max     int
+	used    int
+	waiting int
+	holders map[string]int
+	changed chan struct{}
+}
+
+// Acquire takes permits from the semaphore with provided id, waiting until enough permits are free.
+// max is the total number of permits of the semaphore, the last provided value is used.
+// Zero timeout means no timeout. Permits of the owner are released by UnlockForOwner.
+// Returns ErrLockTimeout if timeout reached or ErrLockCancelled if owner was disconnected while waiting.
+func Acquire(owner, id string, permits, max int, timeout time.Duration) error {
+	log.Debugf("semaphore.Acquire id: %s REQUEST %d of %d permits for owner %s", id, permits, max, owner)
+	if permits <= 0 || permits > max {
+		return ErrInvalidPermits
+	}
+
+	mutexLocker.Lock()
+	defer mutexLocker.Unlock()
+	s, ok := semaphores[id]
+	if !ok {
+		s = &semaphore{holders: map[string]int{}}
+		semaphores[id] = s
+	}
+	if s.max != max {
+		s.max = max
+		s.notify()
+	}
+
+	w := addWaiter(owner, id, waitSemaphore)
+	defer removeWaiter(owner, w)
+	var timer <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+
+	s.waiting++
+	for s.used+permits > s.max {
+		changed := s.changedCh()
+		mutexLocker.Unlock()
+		var timedOut bool
+		select {
+		case <-changed:
+		case <-w.cancel:
+		case <-timer:
+			timedOut = true
+		}
+		mutexLocker.Lock()
+		if w.cancelled || timedOut {
+			s.waiting--
+			s.forget(id)
+			if w.cancelled {
+				log.Debugf("semaphore.Acquire id: %s wait CANCELLED for owner %s", id, owner)
+				return ErrLockCancelled
+			}
+			log.Debugf("semaphore.Acquire id: %s TIMEOUT for owner %s", id, owner)
+			return ErrLockTimeout
+		}
+	}
+	s.waiting--
+
+	s.used += permits
+	s.holders[owner] += permits
+	if _, ok := owners2Semaphores[owner]; !ok {
+		owners2Semaphores[owner] = map[string]struct{}{}
+	}
+	owners2Semaphores[owner][id] = struct{}{}
+	log.Debugf("semaphore.Acquire id: %s ACQUIRED %d permits for owner %s, %d of %d used", id, permits, owner, s.used, s.max)
+
+	return nil
+}
+
+// Release returns permits of the owner to the semaphore. All permits of the owner are released if permits is 0.
+func Release(owner, id string, permits int) error {
+	log.Debugf("semaphore.Release id: %s REQUEST %d permits for owner %s", id, permits, owner)
+	mutexLocker.Lock()
+	defer mutexLocker.Unlock()
+	s, ok := semaphores[id]
+	if !ok || s.holders[owner] == 0 {
+		return ErrNotHolder
+	}
+	if permits < 0 || permits > s.holders[owner] {
+		return ErrInvalidPermits
+	}
+	releasePermits(owner, id, s, permits)
+
+	return nil
+}
+
+// releasePermits releases permits of the owner, or all its permits if permits is 0. mutexLocker must be locked.
+func releasePermits(owner, id string, s *semaphore, permits int) {
+	if permits == 0 {
+		permits = s.holders[owner]
+	}
+	s.used -= permits
+	s.holders[owner] -= permits
+	if s.holders[owner] == 0 {
+		delete(s.holders, owner)
+		delete(owners2Semaphores[owner], id)
+		if len(owners2Semaphores[owner]) == 0 {
+			delete(owners2Semaphores, owner)
+		}
+	}
+	s.notify()
+	s.forget(id)
+}
+
+// releaseSemaphoresForOwner releases all permits of the owner. mutexLocker must be locked.
+func releaseSemaphoresForOwner(owner string) {
+	for id := range owners2Semaphores[owner] {
+		if s, ok := semaphores[id]; ok {
+			releasePermits(owner, id, s, 0)
+		}
+	}
+}
+
+// forget removes semaphore if nobody holds or waits for it. mutexLocker must be locked.
+func (s *semaphore) forget(id string) {
+	if s.used == 0 && s.waiting == 0 && semaphores[id] == s {
+		delete(semaphores, id)
+	}
+}
+
+func (s *semaphore) changedCh() chan struct{} {
+	if s.changed == nil {
+		s.changed = make(chan struct{})
+	}
+
+	return s.changed
+}
+
+func (s *semaphore) notify() {
+	if s.changed != nil {
+		close(s.changed)
+		s.changed = nil
+	}
+}
@@ -0,0 +1,65 @@
+package sync
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+)
+
+func TestSemaphore(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("#Acquire", func() {
+		g.It("should give permits until max reached", func() {
+			id := "10142"
+			g.Assert(Acquire("10124", id, 2, 3, 0)).Equal(nil)
+			g.Assert(Acquire("10125", id, 1, 3, 0)).Equal(nil)
+			g.Assert(Acquire("10126", id, 1, 3, time.Millisecond*10)).Equal(ErrLockTimeout)
+			g.Assert(semaphores[id].used).Equal(3)
+			g.Assert(Release("10124", id, 0)).Equal(nil)
+			g.Assert(Release("10125", id, 1)).Equal(nil)
+			g.Assert(semaphores[id] == nil).IsTrue()
+			g.Assert(owners2Semaphores["10124"] == nil).IsTrue()
+		})
+		g.It("should wake waiter when permits released", func(done Done) {
+			id := "10242"
+			Acquire("10224", id, 1, 1, 0)
+			go func() {
+				g.Assert(Acquire("10225", id, 1, 1, 0)).Equal(nil)
+				Release("10225", id, 0)
+				done()
+			}()
+			time.Sleep(time.Millisecond * 20)
+			Release("10224", id, 0)
+		})
+		g.It("should reject invalid permits", func() {
+			g.Assert(Acquire("10324", "10342", 0, 1, 0)).Equal(ErrInvalidPermits)
+			g.Assert(Acquire("10324", "10342", 2, 1, 0)).Equal(ErrInvalidPermits)
+		})
+	})
+
+	g.Describe("#Release", func() {
+		g.It("should return error if owner holds no permits", func() {
+			g.Assert(Release("10424", "10442", 1)).Equal(ErrNotHolder)
+		})
+	})
+
+	g.Describe("#UnlockForOwner semaphores", func() {
+		g.It("should release permits and cancel waits of the owner", func(done Done) {
+			id := "10542"
+			Acquire("10524", id, 2, 2, 0)
+			go func() {
+				g.Assert(Acquire("10525", id, 1, 2, 0)).Equal(ErrLockCancelled)
+				UnlockForOwner("10524")
+				mutexLocker.Lock()
+				g.Assert(semaphores[id] == nil).IsTrue()
+				g.Assert(owners2Semaphores["10524"] == nil).IsTrue()
+				mutexLocker.Unlock()
+				done()
+			}()
+			time.Sleep(time.Millisecond * 20)
+			UnlockForOwner("10525")
+		})
+	})
+}
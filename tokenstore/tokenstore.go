@@ -0,0 +1,148 @@
+package tokenstore
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/getblank/blank-sr/bdb"
+	"github.com/getblank/blank-sr/berror"
+	"github.com/getblank/blank-sr/config"
+)
+
+// Token purposes
+const (
+	PurposeRegister      = "register"
+	PurposeActivation    = "activation"
+	PurposePasswordReset = "passwordReset"
+	PurposeSSO           = "sso"
+)
+
+var (
+	bucket = "__actionTokens"
+	db     = bdb.DB{}
+
+	// ErrUnknownPurpose returns when token purpose is not supported
+	ErrUnknownPurpose = errors.New("unknown token purpose")
+	// ErrTokenExpired returns when consuming expired token
+	ErrTokenExpired = errors.New("token expired")
+)
+
+// Token represents single-use action token
+type Token struct {
+	Token     string      `json:"token"`
+	Purpose   string      `json:"purpose"`
+	UserID    interface{} `json:"userId"`
+	Data      interface{} `json:"data,omitempty"`
+	CreatedAt time.Time   `json:"createdAt"`
+	TTL       time.Time   `json:"ttl"`
+}
+
+// Init is the entrypoint of tokenstore
+func Init() {
+	go gcWatcher()
+}
+
+// Issue creates new single-use token for purpose and user provided.
+// Token expiration is taken from server settings for the purpose.
+func Issue(purpose string, userID, data interface{}) (*Token, error) {
+	lifetime, err := lifetimeFor(purpose)
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	t := &Token{
+		Token:     hex.EncodeToString(b),
+		Purpose:   purpose,
+		UserID:    userID,
+		Data:      data,
+		CreatedAt: now,
+		TTL:       now.Add(lifetime),
+	}
+
+	if err := db.Save(bucket, key(purpose, t.Token), t); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Consume returns token and deletes it from store, so every token can be consumed only once.
+// Returns berror.DbNotFound if token not exists or was issued for another purpose.
+func Consume(purpose, token string) (*Token, error) {
+	encoded, err := db.Pop(bucket, key(purpose, token))
+	if err != nil {
+		return nil, err
+	}
+
+	var t Token
+	if err := json.Unmarshal(encoded, &t); err != nil {
+		return nil, berror.WrongData
+	}
+
+	if t.TTL.Before(time.Now()) {
+		return nil, ErrTokenExpired
+	}
+
+	return &t, nil
+}
+
+func lifetimeFor(purpose string) (time.Duration, error) {
+	switch purpose {
+	case PurposeRegister, PurposeActivation:
+		return config.RegisterTokenTTL()
+	case PurposePasswordReset:
+		return config.PasswordResetTokenTTL()
+	case PurposeSSO:
+		return config.SSOCodeTTL()
+	}
+
+	return 0, ErrUnknownPurpose
+}
+
+func key(purpose, token string) string {
+	return purpose + ":" + token
+}
+
+func clearExpiredTokens() {
+	tokens, err := db.GetAll(bucket)
+	if err != nil {
+		if err != berror.DbNotFound {
+			log.Error("Can't read all action tokens", err.Error())
+		}
+		return
+	}
+
+	now := time.Now()
+	for _, encoded := range tokens {
+		var t Token
+		if err := json.Unmarshal(encoded, &t); err != nil {
+			log.Error("Can't unmarshal action token", string(encoded), err.Error())
+			continue
+		}
+
+		if t.TTL.Before(now) {
+			if err := db.Delete(bucket, key(t.Purpose, t.Token)); err != nil {
+				log.Error("Can't delete action token", err.Error())
+			}
+		}
+	}
+}
+
+func gcWatcher() {
+	c := time.Tick(time.Minute)
+	for {
+		<-c
+		clearExpiredTokens()
+	}
+}
@@ -0,0 +1,72 @@
+package tokenstore
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+
+	"github.com/getblank/blank-sr/berror"
+)
+
+func TestTokenStore(t *testing.T) {
+	g := Goblin(t)
+	g.Describe("Token Store", func() {
+		g.Before(func() {
+			db.DeleteBucket(bucket)
+		})
+
+		g.Describe("#Issue", func() {
+			g.It("Should create token with expiration", func() {
+				tok, err := Issue(PurposePasswordReset, "123", nil)
+				g.Assert(err == nil).IsTrue()
+				g.Assert(tok.Token != "").IsTrue()
+				g.Assert(tok.TTL.After(time.Now())).IsTrue()
+			})
+			g.It("Should return error for unknown purpose", func() {
+				_, err := Issue("unknown", "123", nil)
+				g.Assert(err).Equal(ErrUnknownPurpose)
+			})
+		})
+
+		g.Describe("#Consume", func() {
+			g.It("Should return token only once", func() {
+				tok, _ := Issue(PurposeActivation, "234", map[string]interface{}{"email": "user@example.com"})
+				consumed, err := Consume(PurposeActivation, tok.Token)
+				g.Assert(err == nil).IsTrue()
+				g.Assert(consumed.UserID).Equal("234")
+				g.Assert(consumed.Data).Equal(map[string]interface{}{"email": "user@example.com"})
+				_, err = Consume(PurposeActivation, tok.Token)
+				g.Assert(err).Equal(berror.DbNotFound)
+			})
+			g.It("Should not consume token issued for another purpose", func() {
+				tok, _ := Issue(PurposeRegister, "345", nil)
+				_, err := Consume(PurposePasswordReset, tok.Token)
+				g.Assert(err).Equal(berror.DbNotFound)
+				_, err = Consume(PurposeRegister, tok.Token)
+				g.Assert(err == nil).IsTrue()
+			})
+			g.It("Should return error for expired token", func() {
+				tok, _ := Issue(PurposeRegister, "456", nil)
+				tok.TTL = time.Now().Add(-time.Second)
+				db.Save(bucket, key(tok.Purpose, tok.Token), tok)
+				_, err := Consume(PurposeRegister, tok.Token)
+				g.Assert(err).Equal(ErrTokenExpired)
+			})
+		})
+
+		g.Describe("#clearExpiredTokens", func() {
+			g.It("Should delete only expired tokens", func() {
+				expired, _ := Issue(PurposeRegister, "567", nil)
+				expired.TTL = time.Now().Add(-time.Second)
+				db.Save(bucket, key(expired.Purpose, expired.Token), expired)
+				valid, _ := Issue(PurposeRegister, "678", nil)
+				clearExpiredTokens()
+				_, err := db.Get(bucket, key(expired.Purpose, expired.Token))
+				g.Assert(err).Equal(berror.DbNotFound)
+				_, err = db.Get(bucket, key(valid.Purpose, valid.Token))
+				g.Assert(err == nil).IsTrue()
+			})
+		})
+	})
+}